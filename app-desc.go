@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"text/template"
@@ -16,6 +17,41 @@ type AppDesc struct {
 	Data     map[string]any
 
 	app *App
+
+	// file and index tell where the app is described, for errors
+	file  string
+	index int
+	// defaults are the project's Data
+	defaults map[string]any
+}
+
+// data returns the template data: the project's defaults merged with the app's Data.
+func (d AppDesc) data() map[string]any {
+	return mergeData(d.defaults, d.Data)
+}
+
+// sourceKey identifies the app's description and the content of the files it references,
+// so an unchanged app doesn't have to be loaded (or rendered) again.
+func (d AppDesc) sourceKey(tree *object.Tree) (key string, err error) {
+	ba, err := json.Marshal(AppDesc{File: d.File, Template: d.Template, Data: d.data()})
+	if err != nil {
+		return
+	}
+	key = string(ba)
+
+	for _, path := range []string{d.File, d.Template} {
+		if path == "" {
+			continue
+		}
+
+		f, fileErr := tree.File(path)
+		if fileErr != nil {
+			err = fmt.Errorf("failed to get file %q: %w", path, fileErr)
+			return
+		}
+		key += ":" + f.Hash.String()
+	}
+	return
 }
 
 func (d AppDesc) GetApp(tree *object.Tree) (app App, err error) {
@@ -57,7 +93,7 @@ func (d AppDesc) GetApp(tree *object.Tree) (app App, err error) {
 			return
 		}
 
-		tmpl := template.New(d.Template)
+		tmpl := template.New(d.Template).Option("missingkey=error").Funcs(templateFuncs)
 		_, err = tmpl.Parse(string(tmplBytes))
 		if err != nil {
 			err = fmt.Errorf("failed to parse template %s: %w", d.Template, err)
@@ -65,7 +101,7 @@ func (d AppDesc) GetApp(tree *object.Tree) (app App, err error) {
 		}
 
 		buf := new(bytes.Buffer)
-		err = tmpl.Execute(buf, d.Data)
+		err = tmpl.Execute(buf, d.data())
 		if err != nil {
 			err = fmt.Errorf("failed to render template %s: %w", d.Template, err)
 			return
@@ -76,6 +112,7 @@ func (d AppDesc) GetApp(tree *object.Tree) (app App, err error) {
 
 	err = yaml.Unmarshal(appBytes, &app)
 	if err != nil {
+		err = fmt.Errorf("failed to parse %s: %w", firstNonEmpty(d.File, d.Template), err)
 		return
 	}
 
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"gopkg.in/yaml.v2"
+)
+
+// readProjectFile reads a project file of the apps repository.
+func readProjectFile(tree *object.Tree, path string) (project Project, err error) {
+	file, err := tree.File(path)
+	if err != nil {
+		err = fmt.Errorf("failed to get file %q: %w", path, err)
+		return
+	}
+
+	f, err := file.Reader()
+	if err != nil {
+		err = fmt.Errorf("failed to create file reader: %w", err)
+		return
+	}
+
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.SetStrict(true)
+
+	err = dec.Decode(&project)
+	if err != nil {
+		err = fmt.Errorf("failed to parse %q: %w", path, err)
+		return
+	}
+
+	for idx, desc := range project.Apps {
+		desc.file, desc.index = path, idx
+		desc.defaults = project.Data
+	}
+	return
+}
+
+// projectInclude is an include glob, the file it comes from and that file's Data, merged
+// with the Data of the files including it.
+type projectInclude struct {
+	from string
+	glob string
+	data map[string]any
+}
+
+// resolveIncludes adds the apps of the included project files, recursively. Globs are
+// relative to the repository's root, and each file is read once. The file and template of
+// included apps are relative to the directory of the file describing them.
+func (project *Project) resolveIncludes(tree *object.Tree) (err error) {
+	var files []string
+	if len(project.Include) != 0 {
+		err = tree.Files().ForEach(func(f *object.File) error {
+			files = append(files, f.Name)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list files: %w", err)
+		}
+		sort.Strings(files)
+	}
+
+	read := map[string]bool{appsFilePath: true}
+	errs := make([]error, 0)
+
+	includes := []projectInclude{}
+	for _, glob := range project.Include {
+		includes = append(includes, projectInclude{appsFilePath, glob, project.Data})
+	}
+
+	for len(includes) != 0 {
+		include := includes[0]
+		includes = includes[1:]
+
+		res, globErr := compileGlobs([]string{include.glob})
+		if globErr != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", include.from, globErr))
+			continue
+		}
+
+		for _, file := range files {
+			if read[file] || !matchAny(res, file) {
+				continue
+			}
+			read[file] = true
+
+			included, readErr := readProjectFile(tree, file)
+			if readErr != nil {
+				errs = append(errs, fmt.Errorf("%s: include %q: %w", include.from, include.glob, readErr))
+				continue
+			}
+
+			// included files' Data override the including files'
+			data := mergeData(include.data, included.Data)
+
+			dir := path.Dir(file)
+			for _, desc := range included.Apps {
+				desc.defaults = data
+				if desc.File != "" {
+					desc.File = path.Join(dir, desc.File)
+				}
+				if desc.Template != "" {
+					desc.Template = path.Join(dir, desc.Template)
+				}
+			}
+
+			project.Apps = append(project.Apps, included.Apps...)
+			for _, glob := range included.Include {
+				includes = append(includes, projectInclude{file, glob, data})
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
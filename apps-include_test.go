@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// testTree commits the files in a memory repository and returns the commit's tree.
+func testTree(files map[string]string) *object.Tree {
+	fs := memfs.New()
+	repo, err := git.Init(memory.NewStorage(), fs)
+	if err != nil {
+		panic(err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		panic(err)
+	}
+
+	for name, content := range files {
+		f, err := fs.Create(name)
+		if err != nil {
+			panic(err)
+		}
+		f.Write([]byte(content))
+		f.Close()
+
+		if _, err = wt.Add(name); err != nil {
+			panic(err)
+		}
+	}
+
+	h, err := wt.Commit("test", &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.org", When: time.Now()},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	commit, err := repo.CommitObject(h)
+	if err != nil {
+		panic(err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		panic(err)
+	}
+	return tree
+}
+
+func Example_resolveIncludes() {
+	tree := testTree(map[string]string{
+		"apps.yaml": `
+include: [ "teams/*/apps.yaml" ]
+data: { env: prod, team: none }
+apps:
+- file: web.yaml
+`,
+		"teams/a/apps.yaml": `
+include: [ "teams/a/more/*.yaml", "teams/*/apps.yaml", "apps.yaml" ]
+data: { team: a }
+apps:
+- template: app.tmpl
+`,
+		"teams/a/more/x.yaml": `
+data: { region: eu }
+apps:
+- file: x.yaml
+  data: { name: x }
+`,
+		"teams/b/apps.yaml": `apps: [`,
+	})
+
+	project, err := readProjectFile(tree, "apps.yaml")
+	if err != nil {
+		panic(err)
+	}
+
+	err = project.resolveIncludes(tree)
+	fmt.Println("error:", err)
+
+	for _, desc := range project.Apps {
+		fmt.Printf("%s%s from %s: %v\n", desc.File, desc.Template, desc.file, desc.data())
+	}
+
+	// Output:
+	// error: apps.yaml: include "teams/*/apps.yaml": failed to parse "teams/b/apps.yaml": yaml: line 1: did not find expected node content
+	// web.yaml from apps.yaml: map[env:prod team:none]
+	// teams/a/app.tmpl from teams/a/apps.yaml: map[env:prod team:a]
+	// teams/a/more/x.yaml from teams/a/more/x.yaml: map[env:prod name:x region:eu team:a]
+}
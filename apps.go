@@ -1,14 +1,19 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"path"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/storage/memory"
 	"github.com/spf13/pflag"
-	"gopkg.in/yaml.v2"
 )
 
 var (
@@ -25,8 +30,87 @@ func init() {
 	pflag.StringVar(&appsFilePath, "apps-file", "apps.yaml", "Apps file path in repository")
 }
 
+// appsVersion is a version of the apps config.
+type appsVersion struct {
+	Commit  string
+	Message string    `json:",omitempty"`
+	Time    time.Time // when it was loaded (or failed to)
+	Error   string    `json:",omitempty"`
+}
+
+// appsStatus tells the active apps config, and the last one failing to load if any.
+var appsStatus = struct {
+	sync.Mutex
+	Active  *appsVersion
+	Failing *appsVersion
+}{}
+
 func updateApps() {
 	log := log.New(log.Writer(), "update apps: ", log.Flags()|log.Lmsgprefix)
+
+	project, commit, errs := loadProject(log, currentProject)
+
+	version := appsVersion{Time: time.Now()}
+	if commit != nil {
+		msg, _, _ := strings.Cut(commit.Message, "\n")
+		version.Commit = commit.ID().String()
+		version.Message = strings.TrimSpace(msg)
+	}
+
+	if len(errs) != 0 {
+		version.Error = errors.Join(errs...).Error()
+
+		appsStatus.Lock()
+		appsStatus.Failing = &version
+		appsStatus.Unlock()
+
+		if appsCommit != "" {
+			notifyf("apps config %s failed to load, keeping %s: %s", shortCommit(version.Commit), shortCommit(appsCommit), version.Error)
+			return
+		}
+
+		// nothing known to be good yet, use what could be loaded
+		notifyf("apps config %s failed to load, using the %d valid apps: %s", shortCommit(version.Commit), len(project.apps), version.Error)
+		if commit == nil {
+			return
+		}
+	} else {
+		appsStatus.Lock()
+		appsStatus.Failing = nil
+		appsStatus.Unlock()
+	}
+
+	log.Printf("loaded %d apps (commit %s: %s)", len(project.apps), shortCommit(version.Commit), version.Message)
+
+	appsStatus.Lock()
+	appsStatus.Active = &version
+	appsStatus.Unlock()
+
+	previousCommit, previous := appsCommit, currentProject
+
+	appsCommit = version.Commit
+	currentProject = project
+
+	if len(errs) == 0 {
+		if err := pruneDeployed(project); err != nil {
+			log.Print("failed to prune deployed images: ", err)
+		}
+	}
+
+	if previousCommit != "" && previousCommit != appsCommit {
+		redeployChanged(previous, project)
+	}
+}
+
+// loadProject loads the apps config from the apps repository. Apps whose files did not
+// change since the previous project are not loaded (or rendered) again.
+func loadProject(log *log.Logger, previous Project) (project Project, commit *object.Commit, errs []error) {
+	fail := func(err error) (Project, *object.Commit, []error) {
+		log.Print(err)
+		errs = append(errs, err)
+		return project, commit, errs
+	}
+
 	//customClient := &http.Client{
 	//	// accept any certificate (might be useful for testing)
 	//	Transport: &http.Transport{
@@ -42,78 +126,81 @@ func updateApps() {
 	storage := memory.NewStorage()
 	r, err := git.Clone(storage, nil, &git.CloneOptions{
 		URL:  appsRepo.URL(),
-		Auth: gitAuth,
+		Auth: gitAuthFor(appsRepo.URL()),
 	})
-
 	if err != nil {
-		log.Printf("failed to clone repo %q: %v", appsRepo.URL(), err)
-		return
+		return fail(fmt.Errorf("failed to clone repo %q: %w", appsRepo.URL(), err))
 	}
 
 	h, err := r.ResolveRevision(plumbing.Revision(appsRepo.Branch))
 	if err != nil {
-		log.Printf("failed to resolve revision %q: %v: ", appsRepo.Branch, err)
-		return
+		return fail(fmt.Errorf("failed to resolve revision %q: %w", appsRepo.Branch, err))
 	}
 
-	commit, err := r.CommitObject(*h)
+	commit, err = r.CommitObject(*h)
 	if err != nil {
-		log.Print("failed to get commit: ", err)
-		return
+		return fail(fmt.Errorf("failed to get commit: %w", err))
 	}
 
 	tree, err := commit.Tree()
 	if err != nil {
-		log.Print("failed to get commit tree: ", err)
-		return
-	}
-
-	appsFile, err := tree.File(appsFilePath)
-	if err != nil {
-		log.Printf("failed to get file %q: %v", appsFilePath, err)
-		return
+		return fail(fmt.Errorf("failed to get commit tree: %w", err))
 	}
 
-	f, err := appsFile.Reader()
+	project, err = readProjectFile(tree, appsFilePath)
 	if err != nil {
-		log.Print("failed to create file reader: ", err)
-		return
+		return fail(err)
 	}
 
-	defer f.Close()
-
-	dec := yaml.NewDecoder(f)
-	dec.SetStrict(true)
-
-	project := Project{}
-	err = dec.Decode(&project)
-	if err != nil {
-		log.Printf("failed to parse %q: %v", appsFilePath, err)
-		return
+	if err = project.resolveIncludes(tree); err != nil {
+		fail(err) // keep the apps that could be read
 	}
 
 	project.apps = make([]App, 0, len(project.Apps))
-	for idx, desc := range project.Apps {
-		app, err := desc.GetApp(tree)
+	project.appsBySource = map[string]App{}
 
+	for _, desc := range project.Apps {
+		key, err := desc.sourceKey(tree)
 		if err != nil {
-			log.Printf("failed to load apps[%d]: %v", idx, err)
+			fail(fmt.Errorf("%s: failed to load apps[%d]: %w", desc.file, desc.index, err))
 			continue
 		}
 
+		app, unchanged := previous.appsBySource[key]
+		if !unchanged {
+			app, err = desc.GetApp(tree)
+			if err == nil {
+				err = app.validate()
+			}
+			if err != nil {
+				fail(fmt.Errorf("%s: failed to load apps[%d]: %w", desc.file, desc.index, err))
+				continue
+			}
+
+			if desc.Template != "" && previous.appsBySource != nil {
+				log.Printf("rendered app %s from template %s", app.Name, desc.Template)
+			}
+		}
+
+		project.appsBySource[key] = app
 		project.apps = append(project.apps, app)
 	}
 
-	msg, _, _ := strings.Cut(commit.Message, "\n")
-	msg = strings.TrimSpace(msg)
-	log.Printf("loaded %d apps (commit %s: %s)", len(project.apps), commit.ID().String()[:7], msg)
-
-	currentProject = project
+	return
 }
 
 type Project struct {
+	// Include are globs of other project files of the apps repository, like "teams/*/apps.yaml".
+	// Globs are relative to the repository's root, but the file and template of the apps
+	// an included file describes are relative to its directory.
+	Include []string
+	// Data are the defaults of the apps' template Data.
+	Data map[string]any
 	Apps []*AppDesc
 	apps []App
+
+	// appsBySource are the apps by their AppDesc's source key
+	appsBySource map[string]App
 }
 
 type App struct {
@@ -121,26 +208,143 @@ type App struct {
 	Deploy     string
 	Builds     []Build
 	DockerArgs []string `yaml:"docker_args"`
+	// CommitAuthor overrides the author of the app's deploy commits
+	CommitAuthor *CommitAuthor `yaml:"commit_author"`
+	// CommitMessage is the template of the app's deploy commit messages (see CommitMessageData)
+	CommitMessage string `yaml:"commit_message"`
+}
+
+// validate checks the parts of the app only used when building or deploying.
+func (app App) validate() (err error) {
+	if app.CommitMessage != "" {
+		if _, err = parseCommitMessage(app.CommitMessage); err != nil {
+			err = fmt.Errorf("app %s: %w", app.Name, err)
+			return
+		}
+	}
+
+	for _, build := range app.Builds {
+		for _, branchInfo := range build.Branches {
+			if branchInfo.SourceTags != "" {
+				if _, err = path.Match(branchInfo.SourceTags, ""); err != nil {
+					err = fmt.Errorf("app %s: invalid source_tags %q: %w", app.Name, branchInfo.SourceTags, err)
+					return
+				}
+			}
+			if !branchInfo.IsPattern() {
+				continue
+			}
+			if _, err = branchInfo.sourceRegexp(); err != nil {
+				err = fmt.Errorf("app %s: invalid branch pattern %q: %w", app.Name, branchInfo.Source, err)
+				return
+			}
+		}
+	}
+	return
 }
 
 type Build struct {
 	Source        string
 	Overlay       string
 	Docker        string
+	Dockerfile    string
+	Context       string
+	Target        string
+	TagTemplate   string `yaml:"tag_template"`
+	Images        []Image
 	Branches      []*BranchInfo
 	DeployUpdates []DeployUpdate `yaml:"deploy_updates"`
 	DockerArgs    []string       `yaml:"docker_args"`
+	Paths         []string
+	IgnorePaths   []string `yaml:"ignore_paths"`
+	CloneDepth    int      `yaml:"clone_depth"`
+	SingleBranch  bool     `yaml:"single_branch"`
+	Submodules    Submodules
+	LFS           bool `yaml:"lfs"`
 }
 
 type BranchInfo struct {
 	Source          string
+	SourceTags      string `yaml:"source_tags"` // glob matching the tags to build instead of a branch
 	Overlay         string
 	Deploy          string
+	Dockerfile      string
+	Context         string
+	Target          string
+	TagTemplate     string   `yaml:"tag_template"`
 	DockerTagSuffix string   `yaml:"docker_tag_suffix"`
 	DockerArgs      []string `yaml:"docker_args"`
+	Preview         *Preview
+	Promote         *Promotion // automatic promotion of the deployed images
+}
+
+// Preview describes an ephemeral environment created in the deploy repository for each
+// branch matching the source, and removed when the branch is deleted.
+type Preview struct {
+	// Template is the directory of the deploy repository copied to create the preview.
+	Template string
+	// Dir is the preview's directory in the deploy repository, like "previews/${1}".
+	Dir string
 }
 
 type DeployUpdate struct {
 	Script  string
 	YamlSet *YamlSet `yaml:"yaml_set"`
 }
+
+// FetchOptions returns the options to fetch the build's source.
+func (b Build) FetchOptions() FetchOptions {
+	return FetchOptions{
+		Depth:        b.CloneDepth,
+		SingleBranch: b.SingleBranch,
+	}
+}
+
+// Image is an image produced by a build. All images of a build share the same tag.
+type Image struct {
+	Name       string
+	Docker     string
+	Dockerfile string
+	Context    string
+	Target     string
+	DockerArgs []string `yaml:"docker_args"`
+}
+
+// DockerImages returns the images to produce; a build without images produces one unnamed image.
+func (b Build) DockerImages() []Image {
+	if len(b.Images) == 0 {
+		return []Image{{}}
+	}
+	return b.Images
+}
+
+// DockerName returns the image's name, without the docker prefix.
+func (b Build) DockerName(image Image) string {
+	if image.Docker != "" {
+		return image.Docker
+	}
+
+	name := firstNonEmpty(b.Docker, repoName(b.Source))
+	if image.Name != "" {
+		name += "-" + image.Name
+	}
+	return name
+}
+
+// DockerBuild returns the dockerfile, context and target to use for the image,
+// the image's values overriding the branch's, overriding the build's.
+func (b Build) DockerBuild(branch *BranchInfo, image Image) (dockerfile, context, target string) {
+	dockerfile = firstNonEmpty(image.Dockerfile, branch.Dockerfile, b.Dockerfile)
+	context = firstNonEmpty(image.Context, branch.Context, b.Context, ".")
+	target = firstNonEmpty(image.Target, branch.Target, b.Target)
+	return
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
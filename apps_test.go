@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+func ExampleBuild_DockerBuild() {
+	build := Build{Source: "web", Dockerfile: "build/Dockerfile", Target: "prod"}
+	branch := &BranchInfo{Source: "main", Context: "app"}
+
+	for _, c := range []struct {
+		build  Build
+		branch *BranchInfo
+		image  Image
+	}{
+		{build, branch, Image{}},
+		{build, branch, Image{Name: "worker", Dockerfile: "worker/Dockerfile", Target: "worker"}},
+		{build, &BranchInfo{}, Image{}},
+		{Build{}, &BranchInfo{}, Image{}},
+	} {
+		dockerfile, context, target := c.build.DockerBuild(c.branch, c.image)
+		fmt.Printf("%q %q %q\n", dockerfile, context, target)
+	}
+
+	// Output:
+	// "build/Dockerfile" "app" "prod"
+	// "worker/Dockerfile" "app" "worker"
+	// "build/Dockerfile" "." "prod"
+	// "" "." ""
+}
+
+func Example_updateApps() {
+	dir, cleanup := testDir("apps-test")
+	defer cleanup()
+
+	defer func(prevWorkDir string, prevRepo RepoRef, prevCommit string, prevProject Project) {
+		*workDir, appsRepo, appsCommit, currentProject = prevWorkDir, prevRepo, prevCommit, prevProject
+		appsStatus.Active, appsStatus.Failing = nil, nil
+	}(*workDir, appsRepo, appsCommit, currentProject)
+	*workDir = dir
+
+	git := testGit(dir)
+
+	git("init", "-q", "-b", "main", "apps")
+	commit := func(appsYAML string) string {
+		os.WriteFile(filepath.Join(dir, "apps", "apps.yaml"), []byte(appsYAML), 0644)
+		git("-C", "apps", "add", ".")
+		git("-C", "apps", "commit", "-q", "-m", "apps")
+		return git("-C", "apps", "rev-parse", "HEAD")
+	}
+
+	os.WriteFile(filepath.Join(dir, "apps", "web.yaml"), []byte("name: web\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "apps", "api.yaml"), []byte("name: api\n"), 0644)
+
+	appsRepo = RepoRef{Repo: "file://" + filepath.Join(dir, "apps"), Branch: "main"}
+
+	status := func(commits ...string) {
+		names := []string{}
+		for _, app := range currentProject.apps {
+			names = append(names, app.Name)
+		}
+		failing := "-"
+		for i, c := range commits {
+			if appsStatus.Failing != nil && appsStatus.Failing.Commit == c {
+				failing = fmt.Sprint("commit ", i+1)
+			}
+		}
+		active := slices.Index(commits, appsCommit) + 1
+		fmt.Printf("active: commit %d, apps: %v, failing: %s\n", active, names, failing)
+	}
+
+	// nothing known to be good: the valid apps are used
+	appsCommit, currentProject = "", Project{}
+	c1 := commit("apps: [ { file: web.yaml }, { file: missing.yaml } ]\n")
+	updateApps()
+	status(c1)
+
+	c2 := commit("apps: [ { file: web.yaml }, { file: api.yaml } ]\n")
+	updateApps()
+	status(c1, c2)
+
+	// the last known good config is kept
+	c3 := commit("apps: [ { file: web.yaml }, { file: missing.yaml } ]\n")
+	updateApps()
+	status(c1, c2, c3)
+
+	c4 := commit("apps: [ { file: api.yaml } ]\n")
+	updateApps()
+	status(c1, c2, c3, c4)
+
+	// Output:
+	// active: commit 1, apps: [web], failing: commit 1
+	// active: commit 2, apps: [web api], failing: -
+	// active: commit 2, apps: [web api], failing: commit 3
+	// active: commit 4, apps: [api], failing: -
+}
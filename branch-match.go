@@ -0,0 +1,129 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// BranchInfo.Source may be:
+//   - a branch name;
+//   - a glob like "feature/*", each "*" (or "**", matching "/" too) being captured as ${1}, ${2}...;
+//   - a regexp prefixed by "~", like "~^feature/(?P<name>.+)$", capturing ${1}... and named groups.
+//
+// Captures, and ${0} for the whole branch name, are expanded in Deploy, Overlay,
+// DockerTagSuffix, Preview.Dir and yaml_set values.
+
+func (b *BranchInfo) IsPattern() bool {
+	return strings.HasPrefix(b.Source, "~") || strings.ContainsAny(b.Source, "*?[")
+}
+
+// Match returns the captures of branch if it matches b's Source.
+func (b *BranchInfo) Match(branch string) (vars map[string]string, ok bool) {
+	if !b.IsPattern() {
+		if b.Source != branch {
+			return
+		}
+		return map[string]string{"0": branch}, true
+	}
+
+	re, err := b.sourceRegexp()
+	if err != nil {
+		return // rejected when loading the apps config
+	}
+
+	match := re.FindStringSubmatch(branch)
+	if match == nil {
+		return
+	}
+
+	vars = map[string]string{}
+	for idx, name := range re.SubexpNames() {
+		vars[strconv.Itoa(idx)] = match[idx]
+		if name != "" {
+			vars[name] = match[idx]
+		}
+	}
+	return vars, true
+}
+
+// sourceRegexp compiles b's Source pattern.
+func (b *BranchInfo) sourceRegexp() (*regexp.Regexp, error) {
+	if expr, isRegexp := strings.CutPrefix(b.Source, "~"); isRegexp {
+		return regexp.Compile(expr)
+	}
+	return regexp.Compile(globRegexp(b.Source))
+}
+
+// Expand returns a copy of b for the given branch, with vars expanded.
+func (b BranchInfo) Expand(branch string, vars map[string]string) *BranchInfo {
+	b.Source = branch
+	b.Deploy = expandVars(b.Deploy, vars)
+	b.Overlay = expandVars(b.Overlay, vars)
+	b.DockerTagSuffix = expandVars(b.DockerTagSuffix, vars)
+	if b.Preview != nil {
+		preview := *b.Preview
+		preview.Dir = expandVars(preview.Dir, vars)
+		b.Preview = &preview
+	}
+	return &b
+}
+
+var varRegexp = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// expandVars replaces ${name} by its value in vars, leaving unknown variables as is.
+func expandVars(s string, vars map[string]string) string {
+	return varRegexp.ReplaceAllStringFunc(s, func(v string) string {
+		if value, ok := vars[v[2:len(v)-1]]; ok {
+			return value
+		}
+		return v
+	})
+}
+
+// globRegexp converts a glob to an anchored regexp, capturing "*" and "**".
+func globRegexp(glob string) string {
+	re := new(strings.Builder)
+	re.WriteString("^")
+
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				re.WriteString("(.*)")
+				i++
+			} else {
+				re.WriteString("([^/]*)")
+			}
+
+		case '?':
+			re.WriteString("[^/]")
+
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				re.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + class + "]")
+			i += end + 1
+
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				re.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+			}
+
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	re.WriteString("$")
+	return re.String()
+}
@@ -0,0 +1,50 @@
+package main
+
+import "fmt"
+
+func ExampleBranchInfo_Match() {
+	for _, b := range []BranchInfo{
+		{Source: "main", Deploy: "main"},
+		{Source: "feature/*", Deploy: "preview-${1}"},
+		{Source: "release/**", Deploy: "release-${1}"},
+		{Source: `~^team-(?P<team>[a-z]+)/(.+)$`, Deploy: "${team}-${2}"},
+	} {
+		for _, branch := range []string{"main", "feature/login", "release/2026/10", "team-ops/fix"} {
+			vars, ok := b.Match(branch)
+			if !ok {
+				continue
+			}
+			fmt.Printf("%s matches %s: deploy to %s\n", branch, b.Source, b.Expand(branch, vars).Deploy)
+		}
+	}
+
+	// Output:
+	// main matches main: deploy to main
+	// feature/login matches feature/*: deploy to preview-login
+	// release/2026/10 matches release/**: deploy to release-2026/10
+	// team-ops/fix matches ~^team-(?P<team>[a-z]+)/(.+)$: deploy to ops-fix
+}
+
+func ExampleApp_validate() {
+	app := App{Name: "web", Builds: []Build{{
+		Source:   "web",
+		Branches: []*BranchInfo{{Source: "~^feature/(.+"}},
+	}}}
+
+	fmt.Println(app.validate())
+
+	// Output:
+	// app web: invalid branch pattern "~^feature/(.+": error parsing regexp: missing closing ): `^feature/(.+`
+}
+
+func ExampleApp_validate_sourceTags() {
+	app := App{Name: "web", Builds: []Build{{
+		Source:   "web",
+		Branches: []*BranchInfo{{SourceTags: "v[0-9"}},
+	}}}
+
+	fmt.Println(app.validate())
+
+	// Output:
+	// app web: invalid source_tags "v[0-9": syntax error in pattern
+}
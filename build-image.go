@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+)
+
+// builtImage is an image built (or found) and pushed by a build run.
+type builtImage struct {
+	Image
+	Ref    string
+	Digest string
+}
+
+func (b *BuildRun) buildImage(ctx context.Context, docker *client.Client, img Image) (built builtImage, err error) {
+	log := b.log
+	app, build, branchInfo := b.app, b.build, b.branch
+
+	dockerImage := dockerPrefix + build.DockerName(img) + ":" + b.imageTag
+
+	built = builtImage{Image: img, Ref: dockerImage}
+
+	// build-args caching is crap so at least check if we already build the target image
+	if b.directives.ForceRebuild {
+		log.Print("image ", dockerImage, ": rebuild forced by the commit message")
+	}
+
+	if _, _, inspectErr := docker.ImageInspectWithRaw(ctx, dockerImage); inspectErr == nil && !b.directives.ForceRebuild {
+		log.Print("image ", dockerImage, " already exists, not rebuilding.")
+	} else {
+		dockerfile, dockerContext, target := build.DockerBuild(branchInfo, img)
+
+		buildCmd := []string{"build", "-t", dockerImage, dockerContext,
+			"--network=host", // we don't really want the network isolation overload
+			"--build-arg=GIT_TAG=" + b.srcTag,
+			"--build-arg=IMAGE_TAG=" + b.imageTag,
+		}
+
+		if dockerfile != "" {
+			buildCmd = append(buildCmd, "--file="+dockerfile)
+		}
+		if target != "" {
+			buildCmd = append(buildCmd, "--target="+target)
+		}
+
+		if sshAuthSock := os.Getenv("SSH_AUTH_SOCK"); sshAuthSock != "" {
+			buildCmd = append(buildCmd, "--ssh=default="+sshAuthSock)
+		}
+
+		if b.overlayTag != "" {
+			buildCmd = append(buildCmd, "--build-arg", "OVERLAY_TAG="+b.overlayTag)
+		}
+
+		for _, args := range [][]string{dockerArgs, app.DockerArgs, build.DockerArgs, branchInfo.DockerArgs, img.DockerArgs} {
+			for _, arg := range args {
+				buildCmd = append(buildCmd, "--build-arg", arg)
+			}
+		}
+
+		err = execCmd(log, b.srcDir, "docker", buildCmd...)
+		if err != nil {
+			return
+		}
+	}
+
+	err = execCmd(log, b.srcDir, "docker", "push", dockerImage)
+	// err = pushImage(log, appDir, dockerImage)
+	if err != nil {
+		return
+	}
+
+	if inspect, _, inspectErr := docker.ImageInspectWithRaw(ctx, dockerImage); inspectErr != nil {
+		log.Print("WARNING: failed to inspect pushed image: ", inspectErr)
+	} else if digest, ok := imageDigest(inspect.RepoDigests, dockerImage); ok {
+		built.Digest = digest
+		log.Print("- pushed ", dockerImage, "@", digest)
+	}
+
+	if signKeyPath != "" {
+		if built.Digest == "" {
+			err = fmt.Errorf("can't sign %s: digest unknown", dockerImage)
+			return
+		}
+
+		err = b.signImage(ctx, built)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// cleanupImages removes old local images of the images built by this run, keeping the
+// latest --keep-images of each name not older than --keep-images-max-age. Images built
+// by this run or currently deployed are never removed.
+func (b *BuildRun) cleanupImages(ctx context.Context, docker *client.Client) {
+	log := b.log
+
+	protected, err := deployedImageRefs()
+	if err != nil {
+		log.Print("WARNING: not cleaning up images: failed to load deployed images: ", err)
+		return
+	}
+
+	names := map[string]bool{}
+	for _, img := range b.images {
+		protected[img.Ref] = true
+
+		name, _ := splitImageTag(img.Ref)
+		names[name] = true
+	}
+
+	myImages := map[string][]localImage{}
+
+	allImages, err := docker.ImageList(ctx, image.ListOptions{All: true})
+	if err != nil {
+		log.Print("WARNING: not cleaning up images: failed to list images: ", err)
+		return
+	}
+
+	for _, img := range allImages {
+		for _, tag := range img.RepoTags {
+			name, _ := splitImageTag(tag)
+			if !names[name] {
+				continue
+			}
+			myImages[name] = append(myImages[name], localImage{
+				Tag:     tag,
+				Created: img.Created,
+			})
+		}
+	}
+
+	minCreated := int64(0)
+	if keepImagesMaxAge != 0 {
+		minCreated = time.Now().Add(-keepImagesMaxAge).Unix()
+	}
+
+	for _, images := range myImages {
+		for _, tag := range imagesToRemove(images, keepImages, minCreated, protected) {
+			log.Print("- removing old image ", tag)
+			_, err := docker.ImageRemove(ctx, tag, image.RemoveOptions{
+				PruneChildren: true,
+			})
+			if err != nil {
+				log.Print("  `-> failed: ", err)
+			}
+		}
+	}
+}
+
+// localImage is a tag of a local image.
+type localImage struct {
+	Tag     string
+	Created int64
+}
+
+// imagesToRemove returns the tags of the images (of a same name) to remove: all but the
+// latest keep ones created after minCreated (a unix time), except the protected ones.
+func imagesToRemove(images []localImage, keep int, minCreated int64, protected map[string]bool) (tags []string) {
+	// sort by created, most recent first
+	sort.SliceStable(images, func(i, j int) bool {
+		return images[i].Created > images[j].Created
+	})
+
+	for idx, img := range images {
+		if idx < keep && img.Created >= minCreated {
+			continue
+		}
+		if protected[img.Tag] {
+			continue
+		}
+		tags = append(tags, img.Tag)
+	}
+	return
+}
+
+// splitImageTag splits an image reference in its name and tag.
+func splitImageTag(ref string) (name, tag string) {
+	if idx := strings.LastIndex(ref, ":"); idx > strings.LastIndex(ref, "/") {
+		return ref[:idx], ref[idx+1:]
+	}
+	return ref, ""
+}
+
+// imageVars returns the substitutions available to deploy updates:
+//   - ${IMAGE_TAG}: the tag shared by all images;
+//   - ${IMAGE}: the reference of the unnamed image;
+//   - ${IMAGE_TAG:<name>} and ${IMAGE:<name>}: the tag and reference of a named image;
+//   - ${IMAGE_DIGEST} and ${IMAGE_DIGEST:<name>}: the registry digests, when known.
+func (b *BuildRun) imageVars() (vars map[string]string) {
+	vars = map[string]string{"IMAGE_TAG": b.imageTag}
+
+	for _, img := range b.images {
+		if img.Name == "" {
+			vars["IMAGE"] = img.Ref
+			if img.Digest != "" {
+				vars["IMAGE_DIGEST"] = img.Digest
+			}
+			continue
+		}
+
+		vars["IMAGE_TAG:"+img.Name] = b.imageTag
+		vars["IMAGE:"+img.Name] = img.Ref
+		if img.Digest != "" {
+			vars["IMAGE_DIGEST:"+img.Name] = img.Digest
+		}
+	}
+
+	return
+}
+
+// expandVars expands image and branch variables, and ${PREVIEW_DIR}.
+func (b *BuildRun) expandVars(s string) string {
+	vars := map[string]string{}
+	for k, v := range b.vars {
+		vars[k] = v
+	}
+	if preview := b.branch.Preview; preview != nil {
+		vars["PREVIEW_DIR"] = preview.Dir
+	}
+	for k, v := range b.imageVars() {
+		vars[k] = v
+	}
+
+	return expandVars(s, vars)
+}
+
+// imageEnv returns the environment given to deploy scripts: IMAGE_TAG, IMAGE
+// and IMAGE_<NAME> for each named image, and their digests (IMAGE_DIGEST and
+// IMAGE_<NAME>_DIGEST) when known.
+func (b *BuildRun) imageEnv() (env []string) {
+	env = []string{"IMAGE_TAG=" + b.imageTag}
+
+	for _, img := range b.images {
+		if img.Name == "" {
+			env = append(env, "IMAGE="+img.Ref)
+			if img.Digest != "" {
+				env = append(env, "IMAGE_DIGEST="+img.Digest)
+			}
+			continue
+		}
+
+		name := strings.Map(func(r rune) rune {
+			switch {
+			case r >= 'a' && r <= 'z':
+				return r - 'a' + 'A'
+			case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+				return r
+			default:
+				return '_'
+			}
+		}, img.Name)
+
+		env = append(env, "IMAGE_"+name+"="+img.Ref)
+		if img.Digest != "" {
+			env = append(env, "IMAGE_"+name+"_DIGEST="+img.Digest)
+		}
+	}
+
+	return
+}
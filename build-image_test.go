@@ -0,0 +1,44 @@
+package main
+
+import "fmt"
+
+func Example_imagesToRemove() {
+	images := []localImage{
+		{Tag: "app:v1", Created: 100},
+		{Tag: "app:v4", Created: 400},
+		{Tag: "app:v2", Created: 200},
+		{Tag: "app:v3", Created: 300},
+		{Tag: "app:v0", Created: 50},
+	}
+	deployed := map[string]bool{"app:v1": true}
+
+	fmt.Println("keep 2:", imagesToRemove(images, 2, 0, deployed))
+	fmt.Println("keep 3, max age:", imagesToRemove(images, 3, 250, deployed))
+	fmt.Println("keep 5, max age:", imagesToRemove(images, 5, 250, nil))
+
+	// Output:
+	// keep 2: [app:v2 app:v0]
+	// keep 3, max age: [app:v2 app:v0]
+	// keep 5, max age: [app:v2 app:v1 app:v0]
+}
+
+func Example_imageVars() {
+	b := &BuildRun{
+		branch:   &BranchInfo{Source: "main"},
+		vars:     map[string]string{"0": "main"},
+		imageTag: "v1.2.0",
+		images: []builtImage{
+			{Image: Image{Name: "api"}, Ref: "registry.example.org/app-api:v1.2.0"},
+			{Image: Image{Name: "worker-jobs"}, Ref: "registry.example.org/app-worker-jobs:v1.2.0", Digest: "sha256:0123"},
+		},
+	}
+
+	fmt.Println(b.expandVars("api: ${IMAGE_TAG:api} worker: ${IMAGE:worker-jobs}@${IMAGE_DIGEST:worker-jobs}"))
+	fmt.Println(b.expandVars("${IMAGE_TAG} on ${0}, unknown: ${IMAGE_TAG:web} ${IMAGE}"))
+	fmt.Println(b.imageEnv())
+
+	// Output:
+	// api: v1.2.0 worker: registry.example.org/app-worker-jobs:v1.2.0@sha256:0123
+	// v1.2.0 on main, unknown: ${IMAGE_TAG:web} ${IMAGE}
+	// [IMAGE_TAG=v1.2.0 IMAGE_API=registry.example.org/app-api:v1.2.0 IMAGE_WORKER_JOBS=registry.example.org/app-worker-jobs:v1.2.0 IMAGE_WORKER_JOBS_DIGEST=sha256:0123]
+}
@@ -8,15 +8,10 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"sort"
 	"strings"
 	"time"
 
-	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/client"
-	"github.com/go-git/go-git/v5"
-	"github.com/go-git/go-git/v5/config"
-	"github.com/go-git/go-git/v5/plumbing/object"
 )
 
 type BuildRun struct {
@@ -24,6 +19,57 @@ type BuildRun struct {
 	build  Build
 	branch *BranchInfo
 	log    *log.Logger
+
+	// srcRef is the source branch, or "refs/tags/<name>" for tag builds
+	srcRef string
+	// vars are the captures of the branch pattern
+	vars map[string]string
+
+	// srcTriggered is true when the build was triggered by a change of its source ref
+	srcTriggered bool
+	directives   commitDirectives
+
+	id            string
+	srcCommit     string
+	srcMessage    string
+	srcAuthor     string
+	overlayCommit string
+	submodules    map[string]string // submodule commits by path
+
+	srcDir     string
+	srcTag     string
+	overlayTag string
+	imageTag   string
+	images     []builtImage
+
+	// rollbackTo is the ID of the build re-deployed by a rollback, rollbackFrom the image tag it replaces
+	rollbackTo   string
+	rollbackFrom string
+	// promotedFrom is the ID of the build whose images are promoted
+	promotedFrom string
+	// redeployOf is the ID of the build whose images are deployed again
+	redeployOf string
+}
+
+func (b *BuildRun) srcRefDesc() string {
+	if tag, ok := strings.CutPrefix(b.srcRef, "refs/tags/"); ok {
+		return "tag " + tag
+	}
+	return "branch " + b.srcRef
+}
+
+// createBuildLog creates the log file of a build, and a logger writing to it and stdout.
+func createBuildLog(buildID string) (logOut *os.File, logger *log.Logger, err error) {
+	logsDir := filepath.Join(*workDir, "logs")
+	os.MkdirAll(logsDir, 0750)
+
+	logOut, err = os.Create(filepath.Join(logsDir, buildID+".log"))
+	if err != nil {
+		return nil, log.Default(), err
+	}
+
+	logger = log.New(io.MultiWriter(logOut, os.Stdout), "", log.Ldate|log.Ltime|log.LUTC)
+	return
 }
 
 func (b *BuildRun) Run() (err error) {
@@ -41,50 +87,100 @@ func (b *BuildRun) Run() (err error) {
 	app, build, branchInfo := b.app, b.build, b.branch
 
 	buildID := newUlid()
+	b.id = buildID
 
-	notifPrefix := fmt.Sprint("[", buildID, "]("+*builderURL+"/build-logs/"+buildID+") running ", app.Name, "/", build.Source, " (branch ", branchInfo.Source, ")")
+	notifPrefix := fmt.Sprint("[", buildID, "]("+*builderURL+"/build-logs/"+buildID+") running ", app.Name, "/", build.Source, " (", b.srcRefDesc(), ")")
+
+	// reason to skip the build, if any
+	skip := ""
 
 	defer func() {
 		if err != nil {
 			notify(notifPrefix + " failed: " + err.Error())
+		} else if skip != "" {
+			log.Print(notifPrefix, " skipped: ", skip)
 		} else {
 			notify(notifPrefix + " successful")
 		}
 	}()
 
-	var logOut *os.File
-	{
-		logsDir := filepath.Join(*workDir, "logs")
-		os.MkdirAll(logsDir, 0750)
-		logFile := filepath.Join(logsDir, buildID+".log")
-
-		logOut, err = os.Create(logFile)
-		if err != nil {
-			log.Print("failed to create log file: ", err)
-			return
-		}
-		defer logOut.Close()
+	logOut, log, err := createBuildLog(buildID)
+	if err != nil {
+		log.Print("failed to create log file: ", err)
+		return
 	}
-	out := io.MultiWriter(logOut, os.Stdout)
-	log := log.New(out, "", log.Ldate|log.Ltime|log.LUTC)
+	defer logOut.Close()
 	b.log = log
 
+	unlock, err := lockWorkDir()
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	defer func() {
+		record := b.record(err)
+		if skip != "" {
+			record.Skipped, record.Deployed = skip, false
+		}
+		if recordErr := saveBuildRecord(record); recordErr != nil {
+			log.Print("WARNING: failed to save build record: ", recordErr)
+		}
+	}()
+
 	g := gitOps{log}
 
-	branch := branchInfo.Source
+	branch := b.srcRef
 
 	// update app & deploy
 	appDir := filepath.Join(*workDir, app.Name)
 
 	// build
-	baseDir := filepath.Join(appDir, "builds", build.Source)
+	baseDir := filepath.Join(appDir, "builds", repoDir(build.Source))
 
 	srcDir := filepath.Join(baseDir, "src")
-	if err = g.FetchBranch(build.Source, branch, srcDir); err != nil {
+	b.srcDir = srcDir
+	if err = g.FetchBranchWith(build.Source, branch, srcDir, build.FetchOptions()); err != nil {
 		err = fmt.Errorf("failed to fetch source: %w", err)
 		return
 	}
 
+	if b.srcCommit, err = g.Commit(srcDir, branch); err != nil {
+		err = fmt.Errorf("failed to get source commit: %w", err)
+		return
+	}
+
+	if build.LFS {
+		if err = g.FetchLFS(build.Source, srcDir); err != nil {
+			return
+		}
+	}
+
+	if build.Submodules != "" {
+		if b.submodules, err = g.UpdateSubmodules(srcDir, build.Submodules); err != nil {
+			return
+		}
+		for subPath, commit := range b.submodules {
+			log.Print("  - submodule ", subPath, " on commit ", commit)
+		}
+	}
+
+	if commit, commitErr := g.HeadCommit(srcDir, branch); commitErr != nil {
+		log.Print("WARNING: failed to read the source commit: ", commitErr)
+	} else {
+		b.srcMessage = commit.Message
+		b.srcAuthor = commit.Author.Name + " <" + commit.Author.Email + ">"
+		if b.directivesApply() {
+			b.directives = parseCommitDirectives(commit.Message)
+		}
+	}
+
+	if b.directives.SkipBuild {
+		skip = "skip requested by the commit message"
+		log.Print("- skipping build: ", skip)
+		return
+	}
+
 	// copy overlay to source
 	overlayDir := ""
 	if build.Overlay != "" {
@@ -95,6 +191,17 @@ func (b *BuildRun) Run() (err error) {
 			return
 		}
 
+		if b.overlayCommit, err = g.Commit(overlayDir, branchInfo.Overlay); err != nil {
+			err = fmt.Errorf("failed to get overlay commit: %w", err)
+			return
+		}
+
+		if build.LFS {
+			if err = g.FetchLFS(build.Overlay, overlayDir); err != nil {
+				return
+			}
+		}
+
 		log.Print("- copying overlay from ", overlayDir)
 		err = filepath.Walk(overlayDir, func(srcPath string, info os.FileInfo, inErr error) (err error) {
 			err = inErr
@@ -149,13 +256,36 @@ func (b *BuildRun) Run() (err error) {
 		}
 	}
 
+	if build.HasPathFilters() && !b.directives.ForceRebuild {
+		skip, err = b.checkPathFilters(g)
+		if err != nil {
+			err = fmt.Errorf("failed to check path filters: %w", err)
+			return
+		}
+		if skip != "" {
+			log.Print("- skipping build: ", skip)
+			return
+		}
+	}
+
 	// run the build
 	var srcTag, imageTag string
 
-	if *tagDescribe {
-		srcTag, err = g.Describe(srcDir, branchInfo.Source)
+	gitTag, isTag := strings.CutPrefix(branch, "refs/tags/")
+	if !isTag {
+		gitTag = ""
+	}
+
+	if isTag {
+		// git allows tags like release/1.0 or v1.0.0+meta that are not valid image tags
+		srcTag = sanitizeTag(gitTag)
+		if srcTag == "" {
+			err = fmt.Errorf("git tag %q can't be used as an image tag", gitTag)
+		}
+	} else if *tagDescribe {
+		srcTag, err = g.Describe(srcDir, branch)
 	} else {
-		srcTag, err = g.Tag(srcDir, branchInfo.Source)
+		srcTag, err = g.Tag(srcDir, branch)
 	}
 	if err != nil {
 		err = fmt.Errorf("failed to get source tag: %w", err)
@@ -179,91 +309,102 @@ func (b *BuildRun) Run() (err error) {
 		imageTag = srcTag + "_overlay." + overlayTag + branchInfo.DockerTagSuffix
 	}
 
-	dockerImage := build.Docker
-	if dockerImage == "" {
-		dockerImage = build.Source
-	}
-	dockerImageName := dockerPrefix + dockerImage
-	dockerImage = dockerImageName + ":" + imageTag
-
-	// build-args caching is crap so at least check if we already build the target image
-	if _, _, inspectErr := docker.ImageInspectWithRaw(ctx, dockerImage); inspectErr == nil {
-		log.Print("image ", dockerImage, " already exists, not rebuilding.")
-	} else {
-		dockerArgs := []string{"build", "-t", dockerImage, ".",
-			"--network=host", // we don't really want the network isolation overload
-			"--build-arg=GIT_TAG=" + srcTag,
-			"--build-arg=IMAGE_TAG=" + imageTag,
-		}
-
-		if sshAuthSock := os.Getenv("SSH_AUTH_SOCK"); sshAuthSock != "" {
-			dockerArgs = append(dockerArgs, "--ssh=default="+sshAuthSock)
+	if tagTemplate := firstNonEmpty(branchInfo.TagTemplate, build.TagTemplate); tagTemplate != "" {
+		imageTag, err = renderImageTag(tagTemplate, TagData{
+			Default:            imageTag,
+			Commit:             b.srcCommit,
+			ShortCommit:        shortCommit(b.srcCommit),
+			Branch:             sanitizeTag(branchInfo.Source),
+			BranchRaw:          branchInfo.Source,
+			Tag:                gitTag,
+			OverlayCommit:      b.overlayCommit,
+			OverlayShortCommit: shortCommit(b.overlayCommit),
+			OverlayBranch:      branchInfo.Overlay,
+			Date:               time.Now().UTC(),
+			BuildID:            buildID,
+			Suffix:             branchInfo.DockerTagSuffix,
+
+			g:          g,
+			srcRef:     branch,
+			srcDir:     srcDir,
+			overlayDir: overlayDir,
+		})
+		if err != nil {
+			return
 		}
 
-		if overlayTag != "" {
-			dockerArgs = append(dockerArgs, "--build-arg", "OVERLAY_TAG="+overlayTag)
+		if err = validateImageTag(imageTag); err != nil {
+			return
 		}
+	}
 
-		for _, args := range [][]string{dockerArgs, app.DockerArgs, build.DockerArgs, branchInfo.DockerArgs} {
-			for _, arg := range args {
-				dockerArgs = append(dockerArgs, "--build-arg", arg)
-			}
-		}
+	b.srcTag, b.overlayTag, b.imageTag = srcTag, overlayTag, imageTag
 
-		err = execCmd(log, srcDir, "docker", dockerArgs...)
+	b.images = nil
+	for _, img := range build.DockerImages() {
+		var built builtImage
+		built, err = b.buildImage(ctx, docker, img)
 		if err != nil {
 			return
 		}
+		b.images = append(b.images, built)
 	}
 
-	err = execCmd(log, srcDir, "docker", "push", dockerImage)
-	// err = pushImage(log, appDir, dockerImage)
-	if err != nil {
+	defer b.cleanupImages(ctx, docker)
+
+	if b.directives.SkipDeploy {
+		log.Print("- not updating deployment: skip requested by the commit message")
 		return
 	}
 
-	// cleanup old images (keep latest N images)
-	type ImageTag struct {
-		Tag     string
-		ImageID string
-		Created int64
-	}
-	myImages := make([]ImageTag, 0)
+	defer func() {
+		if err != nil {
+			return
+		}
 
-	allImages, err := docker.ImageList(ctx, image.ListOptions{All: true})
-	for _, img := range allImages {
-		for _, tag := range img.RepoTags {
-			if !strings.HasPrefix(tag, dockerImage+":") {
-				continue
-			}
-			myImages = append(myImages, ImageTag{
-				Tag:     tag,
-				ImageID: img.ID,
-				Created: img.Created,
-			})
+		refs := make([]string, 0, len(b.images))
+		for _, img := range b.images {
+			refs = append(refs, img.Ref)
+		}
+
+		if recordErr := recordDeployed(deployTarget(app, build, branchInfo), refs); recordErr != nil {
+			log.Print("WARNING: failed to record deployed images: ", recordErr)
 		}
-	}
 
-	// sort by created
-	sort.Slice(myImages, func(i, j int) bool {
-		ti, tj := myImages[i], myImages[j]
-		return ti.Created < tj.Created
-	})
-
-	if len(myImages) > 5 {
-		for _, imageTag := range myImages[5:] {
-			docker.ImageRemove(ctx, imageTag.Tag, image.RemoveOptions{
-				PruneChildren: true,
-			})
+		if branchInfo.Promote != nil {
+			b.schedulePromotion()
 		}
+	}()
+
+	message, err := b.commitMessage()
+	if err != nil {
+		return
 	}
 
+	err = b.deploy(g, message)
+	return
+}
+
+// deploy applies the deploy updates of the build to the deploy repository, and pushes them.
+func (b *BuildRun) deploy(g gitOps, message string) (err error) {
+	log := b.log
+	app, build, branchInfo := b.app, b.build, b.branch
+
+	appDir := filepath.Join(*workDir, app.Name)
+
 	// update the deployment
 	deployDir := filepath.Join(appDir, "deploy")
 	if err = g.FetchBranch(app.Deploy, branchInfo.Deploy, deployDir); err != nil {
 		return
 	}
 
+	if branchInfo.Preview != nil {
+		if err = b.setupPreview(deployDir); err != nil {
+			err = fmt.Errorf("failed to setup preview: %w", err)
+			return
+		}
+	}
+
 	log.Print("- updating deployment repository")
 	for idx, deployUpdate := range build.DeployUpdates {
 		log.Print("  - step ", idx+1)
@@ -274,16 +415,25 @@ func (b *BuildRun) Run() (err error) {
 				return
 			}
 
-			cmd := exec.Command("docker", "run", "--rm",
-				"-v", absDeployDir+":/work", "-w", "/work",
-				"--entrypoint", "/bin/ash",
+			env := b.imageEnv()
+
+			args := []string{"run", "--rm",
+				"-v", absDeployDir + ":/work", "-w", "/work",
+				"--entrypoint", "/bin/ash"}
+			for _, e := range env {
+				name, _, _ := strings.Cut(e, "=")
+				args = append(args, "-e", name)
+			}
+			args = append(args,
 				"alpine:3.18", // FIXME allow configuration of this
 				"-c", deployUpdate.Script)
+
+			cmd := exec.Command("docker", args...)
 			cmd.Dir = deployDir
 			cmd.Stdout = log.Writer()
 			cmd.Stderr = log.Writer()
 
-			cmd.Env = append(os.Environ(), "IMAGE_TAG="+imageTag)
+			cmd.Env = append(os.Environ(), env...)
 
 			err = cmd.Run()
 			if err != nil {
@@ -294,10 +444,11 @@ func (b *BuildRun) Run() (err error) {
 		if set := deployUpdate.YamlSet; set != nil {
 			set := *set
 
+			set.File = b.expandVars(set.File)
 			filePath := filepath.Join(deployDir, set.File)
 
 			origValue := set.Value
-			set.Value = strings.ReplaceAll(origValue, "${IMAGE_TAG}", imageTag)
+			set.Value = b.expandVars(origValue)
 
 			log.Printf("    - yaml set %s:%s to %q (%q)", set.File, set.Path, set.Value, origValue)
 
@@ -320,69 +471,6 @@ func (b *BuildRun) Run() (err error) {
 		}
 	}
 
-	deploy, err := g.Open(deployDir+".git", deployDir)
-	if err != nil {
-		err = fmt.Errorf("failed to open deploy dir: %w", err)
-		return
-	}
-
-	wt, err := deploy.Worktree()
-	if err != nil {
-		err = fmt.Errorf("failed get deploy worktree: %w", err)
-		return
-	}
-
-	status, err := wt.Status()
-	if err != nil {
-		err = fmt.Errorf("failed to get deploy status: %w", err)
-		return
-	}
-
-	if len(status) == 0 {
-		log.Print("  `-> no changes made")
-		return
-	}
-
-	log.Printf("  %d changes:", len(status))
-	for f, st := range status {
-		log.Print("  - ", string([]byte{byte(st.Worktree)}), " ", f)
-		_, err = wt.Add(f)
-		if err != nil {
-			err = fmt.Errorf("failed to add change: %w", err)
-			return
-		}
-	}
-
-	commit, err := wt.Commit("auto-commit: app "+app.Name+": "+build.Source+": image tag "+imageTag,
-		&git.CommitOptions{
-			Author: &object.Signature{
-				Name: "builder",
-				When: time.Now(),
-			},
-		})
-	if err != nil {
-		err = fmt.Errorf("failed to commit on deploy: %w", err)
-		return
-	}
-
-	log.Print("- deploy commit: ", commit)
-
-	opts := git.PushOptions{
-		RemoteName: "origin",
-		Auth:       gitAuth,
-		RefSpecs:   []config.RefSpec{config.RefSpec(branchInfo.Deploy + ":" + branchInfo.Deploy)},
-	}
-
-	log.Print("- git push ", opts.RemoteName, " ", opts.RefSpecs[0])
-
-	if false {
-		err = deploy.Push(&opts) // FIXME does not work (or a least not like git push)
-	} else {
-		cmd := exec.Command("git", "push", opts.RemoteName, string(opts.RefSpecs[0]))
-		cmd.Stderr = os.Stderr
-		cmd.Dir = deployDir + ".git"
-		err = cmd.Run()
-	}
-
+	_, err = g.CommitAndPush(deployDir, branchInfo.Deploy, message, commitAuthor(app))
 	return
 }
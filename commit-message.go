@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// CommitMessageData is given to deploy commit message templates.
+type CommitMessageData struct {
+	// Default is the message the builder would produce without template.
+	Default string
+
+	App    string
+	Source string
+	Ref    string // source branch, or "refs/tags/<name>" for tag builds
+
+	SourceCommit      string
+	SourceShortCommit string
+	SourceSubject     string
+	SourceMessage     string
+	SourceAuthor      string // "name <email>"
+
+	OverlayCommit      string
+	OverlayShortCommit string
+
+	ImageTag string
+	Image    string   // reference of the build's first image
+	Images   []string // references of every image of the build
+
+	BuildID  string
+	BuildURL string
+}
+
+func (b *BuildRun) commitMessageData() (data CommitMessageData) {
+	subject, _, _ := strings.Cut(b.srcMessage, "\n")
+
+	data = CommitMessageData{
+		Default: "auto-commit: app " + b.app.Name + ": " + b.build.Source + ": image tag " + b.imageTag,
+
+		App:    b.app.Name,
+		Source: b.build.Source,
+		Ref:    b.srcRef,
+
+		SourceCommit:      b.srcCommit,
+		SourceShortCommit: shortCommit(b.srcCommit),
+		SourceSubject:     strings.TrimSpace(subject),
+		SourceMessage:     strings.TrimSpace(b.srcMessage),
+		SourceAuthor:      b.srcAuthor,
+
+		OverlayCommit:      b.overlayCommit,
+		OverlayShortCommit: shortCommit(b.overlayCommit),
+
+		ImageTag: b.imageTag,
+
+		BuildID:  b.id,
+		BuildURL: *builderURL + "/build-logs/" + b.id,
+	}
+
+	for _, img := range b.images {
+		data.Images = append(data.Images, img.Ref)
+	}
+	if len(data.Images) != 0 {
+		data.Image = data.Images[0]
+	}
+	return
+}
+
+// commitMessage returns the deploy commit message of the build, with the trailers tracing it back to the build.
+func (b *BuildRun) commitMessage() (message string, err error) {
+	data := b.commitMessageData()
+
+	message = data.Default
+	if b.app.CommitMessage != "" {
+		message, err = renderCommitMessage(b.app.CommitMessage, data)
+		if err != nil {
+			return
+		}
+	}
+
+	message = addTrailers(message,
+		"Build-Id", b.id,
+		"Source-Commit", b.srcCommit)
+	return
+}
+
+func parseCommitMessage(tmplText string) (tmpl *template.Template, err error) {
+	tmpl, err = template.New("commit_message").Option("missingkey=error").Parse(tmplText)
+	if err != nil {
+		err = fmt.Errorf("failed to parse commit message template: %w", err)
+	}
+	return
+}
+
+func renderCommitMessage(tmplText string, data CommitMessageData) (message string, err error) {
+	tmpl, err := parseCommitMessage(tmplText)
+	if err != nil {
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	err = tmpl.Execute(buf, data)
+	if err != nil {
+		err = fmt.Errorf("failed to render commit message template: %w", err)
+		return
+	}
+
+	message = strings.TrimSpace(buf.String())
+	if message == "" {
+		err = fmt.Errorf("commit message template rendered an empty message")
+	}
+	return
+}
+
+// addTrailers appends git trailers (key, value pairs) to a message, skipping empty values.
+func addTrailers(message string, keyValues ...string) string {
+	trailers := new(strings.Builder)
+	for i := 0; i+1 < len(keyValues); i += 2 {
+		if keyValues[i+1] == "" {
+			continue
+		}
+		trailers.WriteString(keyValues[i] + ": " + keyValues[i+1] + "\n")
+	}
+
+	message = strings.TrimRight(message, "\n\t ")
+	if trailers.Len() == 0 {
+		return message + "\n"
+	}
+	return message + "\n\n" + trailers.String()
+}
@@ -0,0 +1,30 @@
+package main
+
+import "fmt"
+
+func Example_addTrailers() {
+	message, _ := renderCommitMessage("deploy {{ .App }} {{ .ImageTag }}\n\n{{ .SourceSubject }} ({{ .SourceAuthor }})\n", CommitMessageData{
+		App:           "web",
+		ImageTag:      "v1.2.0",
+		SourceSubject: "fix login",
+		SourceAuthor:  "Jo <jo@example.org>",
+	})
+
+	fmt.Print(addTrailers(message, "Build-Id", "01H0000000000000000000000", "Source-Commit", ""))
+
+	// Output:
+	// deploy web v1.2.0
+	//
+	// fix login (Jo <jo@example.org>)
+	//
+	// Build-Id: 01H0000000000000000000000
+}
+
+func ExampleApp_validate_commitMessage() {
+	app := App{Name: "web", CommitMessage: "deploy {{ .ImageTag"}
+
+	fmt.Println(app.validate())
+
+	// Output:
+	// app web: failed to parse commit message template: template: commit_message:1: unclosed action
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func Example_sshSigner() {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	message := "tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n\ndeploy\n"
+
+	for _, key := range []crypto.Signer{edKey, rsaKey} {
+		signer, err := ssh.NewSignerFromSigner(key)
+		if err != nil {
+			panic(err)
+		}
+
+		armored, err := sshSigner{signer}.Sign(strings.NewReader(message))
+		if err != nil {
+			panic(err)
+		}
+
+		lines := strings.Split(string(armored), "\n")
+		fmt.Println(lines[0], lines[len(lines)-1])
+
+		encoded := ""
+		for _, line := range lines[1 : len(lines)-1] {
+			if len(line) > 70 {
+				panic("armored line too long: " + line)
+			}
+			encoded += line
+		}
+
+		blob, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			panic(err)
+		}
+
+		var sigBlob struct {
+			Magic     [6]byte
+			Version   uint32
+			PublicKey string
+			Namespace string
+			Reserved  string
+			HashAlgo  string
+			Signature string
+		}
+		if err = ssh.Unmarshal(blob, &sigBlob); err != nil {
+			panic(err)
+		}
+
+		pubKey, err := ssh.ParsePublicKey([]byte(sigBlob.PublicKey))
+		if err != nil {
+			panic(err)
+		}
+
+		sig := new(ssh.Signature)
+		if err = ssh.Unmarshal([]byte(sigBlob.Signature), sig); err != nil {
+			panic(err)
+		}
+
+		// the signed data, as git (ssh-keygen -Y verify) rebuilds it
+		h := sha512.Sum512([]byte(message))
+		signedData := ssh.Marshal(struct {
+			Magic     [6]byte
+			Namespace string
+			Reserved  string
+			HashAlgo  string
+			Hash      string
+		}{sigBlob.Magic, sigBlob.Namespace, "", sigBlob.HashAlgo, string(h[:])})
+
+		fmt.Println(string(sigBlob.Magic[:]), sigBlob.Version, pubKey.Type(), sigBlob.Namespace, sigBlob.HashAlgo, sig.Format,
+			pubKey.Verify(signedData, sig))
+	}
+
+	// Output:
+	// -----BEGIN SSH SIGNATURE----- -----END SSH SIGNATURE-----
+	// SSHSIG 1 ssh-ed25519 git sha512 ssh-ed25519 <nil>
+	// -----BEGIN SSH SIGNATURE----- -----END SSH SIGNATURE-----
+	// SSHSIG 1 ssh-rsa git sha512 rsa-sha2-512 <nil>
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	gitCredentialsFile string
+	gitCredentials     []*gitCredential
+)
+
+func init() {
+	pflag.StringVar(&gitCredentialsFile, "git-credentials", "", "YAML file mapping git URL prefixes to credentials")
+}
+
+// gitCredential is an entry of the credentials file, like:
+//
+//	# credentials.yaml
+//	- prefix: https://git.example.org/
+//	  token_file: /secrets/git-token
+//	- prefix: https://vendor.example.com/
+//	  username: builder
+//	  password_file: /secrets/vendor-password
+//	- prefix: "git@git.other.org:"
+//	  ssh_user: git
+//	  ssh_key_file: /secrets/id_ed25519
+//	- prefix: ssh://git@git.agent.org/
+//	  ssh_user: git
+//	  ssh_agent: true
+type gitCredential struct {
+	Prefix string
+
+	Token     string
+	TokenFile string `yaml:"token_file"`
+
+	Username     string
+	Password     string
+	PasswordFile string `yaml:"password_file"`
+
+	SSHUser        string `yaml:"ssh_user"`
+	SSHKeyFile     string `yaml:"ssh_key_file"`
+	SSHKeyPassword string `yaml:"ssh_key_password"` // can't be used to push deploy repositories
+	SSHAgent       bool   `yaml:"ssh_agent"`
+
+	auth transport.AuthMethod
+}
+
+func loadGitCredentials(path string) (err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+
+	creds := make([]*gitCredential, 0)
+	if err = dec.Decode(&creds); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	for idx, cred := range creds {
+		if cred.Prefix == "" {
+			return fmt.Errorf("%s: entry %d: prefix is mandatory", path, idx)
+		}
+
+		if cred.auth, err = cred.authMethod(); err != nil {
+			return fmt.Errorf("%s: entry %d (%s): %w", path, idx, cred.Prefix, err)
+		}
+	}
+
+	gitCredentials = creds
+	log.Printf("loaded %d git credentials from %s", len(creds), path)
+	return
+}
+
+func readSecret(value, file string) (string, error) {
+	if file == "" {
+		return value, nil
+	}
+
+	ba, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(ba)), nil
+}
+
+func (c *gitCredential) authMethod() (auth transport.AuthMethod, err error) {
+	switch {
+	case c.Token != "" || c.TokenFile != "":
+		token, err := readSecret(c.Token, c.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		return &githttp.TokenAuth{Token: token}, nil
+
+	case c.Username != "":
+		password, err := readSecret(c.Password, c.PasswordFile)
+		if err != nil {
+			return nil, err
+		}
+		return &githttp.BasicAuth{Username: c.Username, Password: password}, nil
+
+	case c.SSHKeyFile != "":
+		return gitssh.NewPublicKeysFromFile(c.sshUser(), c.SSHKeyFile, c.SSHKeyPassword)
+
+	case c.SSHAgent:
+		return gitssh.NewSSHAgentAuth(c.sshUser())
+
+	default:
+		return nil, fmt.Errorf("no authentication method defined")
+	}
+}
+
+func (c *gitCredential) sshUser() string {
+	if c.SSHUser != "" {
+		return c.SSHUser
+	}
+	return "git"
+}
+
+// findGitCredential returns the credential with the longest prefix of url.
+func findGitCredential(url string) (found *gitCredential) {
+	for _, cred := range gitCredentials {
+		if !strings.HasPrefix(url, cred.Prefix) {
+			continue
+		}
+		if found == nil || len(cred.Prefix) > len(found.Prefix) {
+			found = cred
+		}
+	}
+	return
+}
+
+// gitAuthFor returns the authentication to use for the given git URL.
+func gitAuthFor(url string) transport.AuthMethod {
+	if cred := findGitCredential(url); cred != nil {
+		return cred.auth
+	}
+	return gitAuth
+}
+
+// gitCLIEnv returns the environment giving the git CLI the credentials of the given URL,
+// and the trusted SSH host keys. SSH keys with a passphrase can't be given to the CLI.
+func gitCLIEnv(url string) (env []string, err error) {
+	keyFile, keyPassword := sshKeyFile, os.Getenv("GIT_SSH_KEY_PASSWORD")
+	var auth transport.AuthMethod
+
+	if cred := findGitCredential(url); cred != nil {
+		keyFile, keyPassword, auth = cred.SSHKeyFile, cred.SSHKeyPassword, cred.auth
+	}
+
+	header := ""
+	switch auth := auth.(type) {
+	case *githttp.TokenAuth:
+		header = "Authorization: Bearer " + auth.Token
+	case *githttp.BasicAuth:
+		header = "Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(auth.Username+":"+auth.Password))
+	}
+
+	if header != "" {
+		// given through the environment to not expose it in the command line
+		env = []string{
+			"GIT_CONFIG_COUNT=1",
+			"GIT_CONFIG_KEY_0=http.extraHeader",
+			"GIT_CONFIG_VALUE_0=" + header,
+		}
+		return
+	}
+
+	if keyFile != "" && keyPassword != "" {
+		if ep, epErr := transport.NewEndpoint(url); epErr == nil && ep.Protocol == "ssh" {
+			err = fmt.Errorf("SSH key %s has a passphrase, which the git CLI can't use to push to %s: use a key without passphrase, or the SSH agent", keyFile, url)
+			return
+		}
+	}
+
+	if sshCmd := sshCommand(keyFile); sshCmd != "" {
+		env = []string{"GIT_SSH_COMMAND=" + sshCmd}
+	}
+
+	return
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+)
+
+// CommitAndPush commits every change in the worktree and pushes it to the remote branch.
+func (g gitOps) CommitAndPush(dir, branch, message string, author CommitAuthor) (committed bool, err error) {
+	log := g.log
+
+	deploy, err := g.Open(dir+".git", dir)
+	if err != nil {
+		err = fmt.Errorf("failed to open deploy dir: %w", err)
+		return
+	}
+
+	wt, err := deploy.Worktree()
+	if err != nil {
+		err = fmt.Errorf("failed get deploy worktree: %w", err)
+		return
+	}
+
+	status, err := wt.Status()
+	if err != nil {
+		err = fmt.Errorf("failed to get deploy status: %w", err)
+		return
+	}
+
+	if len(status) == 0 {
+		log.Print("  `-> no changes made")
+		return
+	}
+
+	log.Printf("  %d changes:", len(status))
+	for f, st := range status {
+		log.Print("  - ", string([]byte{byte(st.Worktree)}), " ", f)
+		_, err = wt.Add(f)
+		if err != nil {
+			err = fmt.Errorf("failed to add change: %w", err)
+			return
+		}
+	}
+
+	signer, err := loadCommitSigner()
+	if err != nil {
+		err = fmt.Errorf("failed to load commit signing key: %w", err)
+		return
+	}
+
+	commit, err := wt.Commit(message,
+		&git.CommitOptions{
+			Author: author.signature(),
+			Signer: signer,
+		})
+	if err != nil {
+		err = fmt.Errorf("failed to commit on deploy: %w", err)
+		return
+	}
+
+	committed = true
+	log.Print("- deploy commit: ", commit)
+
+	remote, err := deploy.Remote("origin")
+	if err != nil {
+		err = fmt.Errorf("failed to get remote origin: %w", err)
+		return
+	}
+	remoteURL := remote.Config().URLs[0]
+
+	opts := git.PushOptions{
+		RemoteName: "origin",
+		Auth:       gitAuthFor(remoteURL),
+		RefSpecs:   []config.RefSpec{config.RefSpec(branch + ":" + branch)},
+	}
+
+	log.Print("- git push ", opts.RemoteName, " ", opts.RefSpecs[0])
+
+	if false {
+		err = deploy.Push(&opts) // FIXME does not work (or a least not like git push)
+	} else {
+		var env []string
+		if env, err = gitCLIEnv(remoteURL); err != nil {
+			return
+		}
+
+		cmd := exec.Command("git", "push", opts.RemoteName, string(opts.RefSpecs[0]))
+		cmd.Stderr = os.Stderr
+		cmd.Dir = dir + ".git"
+		cmd.Env = append(os.Environ(), env...)
+		err = cmd.Run()
+	}
+
+	return
+}
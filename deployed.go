@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// deployed images are recorded per deployment target so their local copies are
+// never cleaned up while a deploy branch references them.
+//
+// This is the builder's own bookkeeping, not a read of the deploy branches: each deployment
+// replaces its target's images, but changes made directly in the deploy repository (like a
+// manual rollback) are not seen, so the images they reference are not protected.
+var deployedLock sync.Mutex
+
+// deployTarget identifies where a build is deployed.
+func deployTarget(app App, build Build, branchInfo *BranchInfo) string {
+	target := app.Name + ":" + branchInfo.Deploy + ":" + build.DockerName(Image{})
+	if branchInfo.Preview != nil {
+		target += ":" + branchInfo.Preview.Dir
+	}
+	return target
+}
+
+func deployedFile() string {
+	return filepath.Join(*workDir, "deployed-images.json")
+}
+
+func loadDeployed() (deployed map[string][]string, err error) {
+	deployed = map[string][]string{}
+
+	ba, err := os.ReadFile(deployedFile())
+	if errors.Is(err, fs.ErrNotExist) {
+		err = nil
+		return
+	} else if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(ba, &deployed)
+	return
+}
+
+func saveDeployed(deployed map[string][]string) (err error) {
+	ba, err := json.MarshalIndent(deployed, "", "  ")
+	if err != nil {
+		return
+	}
+
+	tmp := deployedFile() + ".tmp"
+	if err = os.WriteFile(tmp, ba, 0600); err != nil {
+		return
+	}
+	return os.Rename(tmp, deployedFile())
+}
+
+// recordDeployed replaces the images deployed on the target (removing it if imageRefs is empty).
+func recordDeployed(target string, imageRefs []string) (err error) {
+	deployedLock.Lock()
+	defer deployedLock.Unlock()
+
+	deployed, err := loadDeployed()
+	if err != nil {
+		return
+	}
+
+	if len(imageRefs) == 0 {
+		delete(deployed, target)
+	} else {
+		deployed[target] = imageRefs
+	}
+
+	return saveDeployed(deployed)
+}
+
+// pruneDeployed forgets the images deployed on targets the project can't deploy to anymore.
+func pruneDeployed(project Project) (err error) {
+	deployedLock.Lock()
+	defer deployedLock.Unlock()
+
+	deployed, err := loadDeployed()
+	if err != nil {
+		return
+	}
+
+	pruned := false
+	for target := range deployed {
+		if !project.mayDeployTo(target) {
+			delete(deployed, target)
+			pruned = true
+		}
+	}
+
+	if !pruned {
+		return
+	}
+	return saveDeployed(deployed)
+}
+
+// mayDeployTo returns true if a branch config of the project may deploy to the target.
+// Targets of pattern configs depend on the matched branch, so their variables match anything.
+func (project Project) mayDeployTo(target string) bool {
+	for _, app := range project.apps {
+		if !strings.HasPrefix(target, app.Name+":") {
+			continue
+		}
+
+		for _, build := range app.Builds {
+			for _, branchInfo := range build.Branches {
+				switch {
+				case branchInfo.IsPattern():
+					parts := varRegexp.Split(deployTarget(app, build, branchInfo), -1)
+					for i := range parts {
+						parts[i] = regexp.QuoteMeta(parts[i])
+					}
+					if regexp.MustCompile("^" + strings.Join(parts, ".*") + "$").MatchString(target) {
+						return true
+					}
+
+				case branchInfo.SourceTags != "":
+					if deployTarget(app, build, branchInfo) == target {
+						return true
+					}
+
+				default:
+					vars, _ := branchInfo.Match(branchInfo.Source)
+					if deployTarget(app, build, branchInfo.Expand(branchInfo.Source, vars)) == target {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
+// deployedImageRefs returns the set of image references currently deployed.
+func deployedImageRefs() (refs map[string]bool, err error) {
+	deployedLock.Lock()
+	defer deployedLock.Unlock()
+
+	deployed, err := loadDeployed()
+	if err != nil {
+		return
+	}
+
+	refs = map[string]bool{}
+	for _, imageRefs := range deployed {
+		for _, ref := range imageRefs {
+			refs[ref] = true
+		}
+	}
+	return
+}
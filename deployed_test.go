@@ -0,0 +1,31 @@
+package main
+
+import "fmt"
+
+func Example_mayDeployTo() {
+	project := Project{apps: []App{{
+		Name: "web",
+		Builds: []Build{{
+			Source: "web",
+			Branches: []*BranchInfo{
+				{Source: "main", Deploy: "staging"},
+				{Source: "feature/*", Deploy: "preview-${1}"},
+			},
+		}},
+	}}}
+
+	for _, target := range []string{
+		"web:staging:web",
+		"web:preview-login:web",
+		"web:production:web", // not deployed to by the current config
+		"api:staging:api",    // app removed
+	} {
+		fmt.Println(target, project.mayDeployTo(target))
+	}
+
+	// Output:
+	// web:staging:web true
+	// web:preview-login:web true
+	// web:production:web false
+	// api:staging:api false
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+)
+
+// commitDirectives are read from the message of the source's head commit.
+type commitDirectives struct {
+	SkipBuild    bool // [skip build], [skip ci] or [ci skip]
+	SkipDeploy   bool // [skip deploy]: build and push, but don't update the deploy repository
+	ForceRebuild bool // [force rebuild]: rebuild images even if they already exist
+}
+
+func parseCommitDirectives(message string) (d commitDirectives) {
+	message = strings.ToLower(message)
+
+	for _, s := range []string{"[skip build]", "[skip ci]", "[ci skip]"} {
+		if strings.Contains(message, s) {
+			d.SkipBuild = true
+		}
+	}
+
+	d.SkipDeploy = strings.Contains(message, "[skip deploy]")
+	d.ForceRebuild = strings.Contains(message, "[force rebuild]")
+	return
+}
+
+// directivesApply returns true if the source commit's directives apply to the build: when
+// its source ref triggered it, or when its source commit was not built yet. Otherwise (ie:
+// overlay changes), the directives were already applied by the source commit's build.
+func (b *BuildRun) directivesApply() bool {
+	if b.srcTriggered {
+		return true
+	}
+
+	last, err := lastBuild(b.app.Name, b.build.DockerName(Image{}), b.srcRef)
+	if err != nil {
+		b.log.Print("WARNING: failed to read build history: ", err)
+		return true
+	}
+	return last == nil || last.SrcCommit != b.srcCommit
+}
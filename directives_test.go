@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+func Example_parseCommitDirectives() {
+	for _, message := range []string{
+		"fix: typo",
+		"docs: update README [skip ci]",
+		"chore: bump version\n\n[CI SKIP]",
+		"feat: new API [skip deploy]",
+		"build: new base image [force rebuild] [skip deploy]",
+		"fix: skip deploy when empty",
+	} {
+		fmt.Printf("%+v\n", parseCommitDirectives(message))
+	}
+
+	// Output:
+	// {SkipBuild:false SkipDeploy:false ForceRebuild:false}
+	// {SkipBuild:true SkipDeploy:false ForceRebuild:false}
+	// {SkipBuild:true SkipDeploy:false ForceRebuild:false}
+	// {SkipBuild:false SkipDeploy:true ForceRebuild:false}
+	// {SkipBuild:false SkipDeploy:true ForceRebuild:true}
+	// {SkipBuild:false SkipDeploy:false ForceRebuild:false}
+}
+
+func Example_directivesApply() {
+	dir, cleanup := testDir("directives-test")
+	defer cleanup()
+
+	defer func(prevWorkDir string) { *workDir = prevWorkDir }(*workDir)
+	*workDir = dir
+
+	run := func(srcTriggered bool, srcCommit string) *BuildRun {
+		return &BuildRun{
+			app:    App{Name: "web"},
+			build:  Build{Source: "web"},
+			branch: &BranchInfo{Source: "main"},
+			srcRef: "main",
+			log:    log.New(os.Stderr, "", 0),
+
+			srcTriggered: srcTriggered,
+			srcCommit:    srcCommit,
+		}
+	}
+
+	fmt.Println("never built:", run(false, "c1").directivesApply())
+
+	// c1 is "[skip build]", its build was skipped
+	saveBuildRecord(BuildRecord{ID: "1", App: "web", Build: "web", SrcRef: "main", SrcCommit: "c1", Success: true, Skipped: "skip requested by the commit message"})
+
+	fmt.Println("source push:", run(true, "c1").directivesApply())
+	fmt.Println("overlay push:", run(false, "c1").directivesApply())
+	fmt.Println("overlay push, new source commit:", run(false, "c2").directivesApply())
+
+	// Output:
+	// never built: true
+	// source push: true
+	// overlay push: false
+	// overlay push, new source commit: true
+}
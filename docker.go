@@ -1,15 +1,99 @@
 package main
 
-import "github.com/spf13/pflag"
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/build"
+	"github.com/docker/docker/client"
+	units "github.com/docker/go-units"
+	"github.com/spf13/pflag"
+)
 
 var (
 	dockerPrefix string
 
 	// may be useless is we move to buildkit?
 	dockerArgs []string
+
+	keepImages       int
+	keepImagesMaxAge time.Duration
+
+	builderPruneThreshold string
+	builderPruneInterval  time.Duration
 )
 
 func init() {
 	pflag.StringVar(&dockerPrefix, "docker-prefix", "", "prefix of produced docker images")
 	pflag.StringSliceVar(&dockerArgs, "docker-arg", nil, "extra args for docker build")
+
+	pflag.IntVar(&keepImages, "keep-images", 5, "number of local images to keep per image name")
+	pflag.DurationVar(&keepImagesMaxAge, "keep-images-max-age", 0, "remove local images older than this (0 for no limit)")
+
+	pflag.StringVar(&builderPruneThreshold, "builder-prune-threshold", "", "prune the docker builder cache down to this size when it exceeds it (ie: 20GB)")
+	pflag.DurationVar(&builderPruneInterval, "builder-prune-interval", time.Hour, "interval between builder cache checks")
+}
+
+func pruneBuilderCacheLoop() {
+	if builderPruneThreshold == "" {
+		return
+	}
+
+	threshold, err := units.FromHumanSize(builderPruneThreshold)
+	if err != nil {
+		log.Fatal("invalid --builder-prune-threshold: ", err)
+	}
+
+	for {
+		globalLock.Lock()
+		err := pruneBuilderCache(threshold)
+		globalLock.Unlock()
+
+		if err != nil {
+			log.Print("builder cache prune failed: ", err)
+		}
+
+		time.Sleep(builderPruneInterval)
+	}
+}
+
+func pruneBuilderCache(threshold int64) (err error) {
+	docker, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		return
+	}
+	defer docker.Close()
+
+	ctx := context.Background()
+
+	usage, err := docker.DiskUsage(ctx, types.DiskUsageOptions{
+		Types: []types.DiskUsageObject{types.BuildCacheObject},
+	})
+	if err != nil {
+		return
+	}
+
+	size := int64(0)
+	for _, record := range usage.BuildCache {
+		size += record.Size
+	}
+
+	if size <= threshold {
+		return
+	}
+
+	log.Printf("builder cache is %s, pruning down to %s", units.HumanSize(float64(size)), units.HumanSize(float64(threshold)))
+
+	report, err := docker.BuildCachePrune(ctx, build.CachePruneOptions{
+		KeepStorage:  threshold,
+		MaxUsedSpace: threshold,
+	})
+	if err != nil {
+		return
+	}
+
+	log.Printf("builder cache pruned: %s reclaimed", units.HumanSize(float64(report.SpaceReclaimed)))
+	return
 }
@@ -1,14 +1,18 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"os/exec"
+	"regexp"
 	"slices"
 	"strings"
 
 	"github.com/go-git/go-billy/v5/osfs"
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/cache"
 	"github.com/go-git/go-git/v5/plumbing/object"
@@ -50,10 +54,31 @@ func init() {
 	}
 }
 
+// scpLikeRegexp matches scp-like git URLs (user@host:path).
+var scpLikeRegexp = regexp.MustCompile(`^[^@/:]+@[^/:]+:`)
+
+// isFullGitURL returns true if repo is a full URL, and not a name relative to the git prefix.
+func isFullGitURL(repo string) bool {
+	return strings.Contains(repo, "://") || scpLikeRegexp.MatchString(repo)
+}
+
 func gitURL(repo string) string {
+	if isFullGitURL(repo) {
+		return repo
+	}
 	return gitPrefix + repo
 }
 
+// canonicalRepo returns the host and path of a git URL, so the different URLs of a
+// repository (https, ssh, scp-like, with or without .git) are comparable.
+func canonicalRepo(url string) string {
+	ep, err := transport.NewEndpoint(url)
+	if err != nil {
+		return url
+	}
+	return strings.ToLower(ep.Host) + "/" + strings.TrimSuffix(strings.Trim(ep.Path, "/"), ".git")
+}
+
 func cutAllowedPrefix(url string) (base string, ok bool) {
 	base, ok = strings.CutPrefix(url, gitPrefix)
 	if ok {
@@ -74,7 +99,19 @@ type gitOps struct {
 	log *log.Logger
 }
 
+// FetchOptions tune how a repository is fetched.
+type FetchOptions struct {
+	// Depth limits the fetched history (0 for the full history).
+	Depth int
+	// SingleBranch only fetches the requested branch (or tag).
+	SingleBranch bool
+}
+
 func (g gitOps) FetchBranch(repoURL, branch, targetDir string) (err error) {
+	return g.FetchBranchWith(repoURL, branch, targetDir, FetchOptions{})
+}
+
+func (g gitOps) FetchBranchWith(repoURL, branch, targetDir string, fetchOpts FetchOptions) (err error) {
 	log := g.log
 
 	repoURL = gitURL(repoURL)
@@ -83,12 +120,31 @@ func (g gitOps) FetchBranch(repoURL, branch, targetDir string) (err error) {
 
 	log.Print("- fetching ", repoURL, " branch ", branch, " to ", dir)
 
+	partial := fetchOpts.Depth > 0 || fetchOpts.SingleBranch
+
 retry:
 	isFresh := true
-	repo, err := git.PlainClone(dir, true, &git.CloneOptions{
-		URL:  repoURL,
-		Auth: gitAuth,
-	})
+	var repo *git.Repository
+	if partial {
+		// don't clone, we only want what the fetch below gets
+		repo, err = git.PlainInit(dir, true)
+		if err == nil {
+			_, err = repo.CreateRemote(&config.RemoteConfig{
+				Name: "origin",
+				URLs: []string{repoURL},
+			})
+			if err != nil {
+				os.RemoveAll(dir)
+				err = fmt.Errorf("failed to init %s: %w", dir, err)
+				return
+			}
+		}
+	} else {
+		repo, err = git.PlainClone(dir, true, &git.CloneOptions{
+			URL:  repoURL,
+			Auth: gitAuthFor(repoURL),
+		})
+	}
 
 	if err == git.ErrRepositoryAlreadyExists {
 		isFresh = false
@@ -111,14 +167,24 @@ retry:
 
 	if !isFresh && !slices.Contains(remote.Config().URLs, repoURL) {
 		log.Printf("remote for origin is not %q, cloning from scratch", repoURL)
+		os.RemoveAll(dir)
 		os.RemoveAll(targetDir)
 		goto retry
 	}
 
-	err = remote.Fetch(&git.FetchOptions{
-		Auth: gitAuth,
+	opts := &git.FetchOptions{
+		Auth: gitAuthFor(repoURL),
 		Tags: git.AllTags,
-	})
+	}
+	if fetchOpts.SingleBranch {
+		opts.RefSpecs = []config.RefSpec{fetchRefSpec(branch)}
+	}
+	if fetchOpts.Depth > 0 {
+		opts.Depth = fetchOpts.Depth
+		opts.Tags = git.TagFollowing
+	}
+
+	err = remote.Fetch(opts)
 	if err != nil {
 		if err != git.NoErrAlreadyUpToDate {
 			return
@@ -135,6 +201,54 @@ retry:
 	return
 }
 
+// fetchRefSpec returns the refspec fetching only the given branch or tag.
+func fetchRefSpec(branch string) config.RefSpec {
+	if isTagRef(branch) {
+		return config.RefSpec("+" + branch + ":" + branch)
+	}
+	return config.RefSpec("+" + plumbing.NewBranchReferenceName(branch).String() + ":" +
+		plumbing.NewRemoteReferenceName("origin", branch).String())
+}
+
+// Deepen fetches the full history and tags of a shallow repository's branch. go-git
+// doesn't fetch commits it already has the tip of, so this is done by the git CLI.
+func (g gitOps) Deepen(dir, branch string) (err error) {
+	repo, err := git.PlainOpen(dir + ".git")
+	if err != nil {
+		return
+	}
+
+	remote, err := repo.Remote("origin")
+	if err != nil {
+		err = fmt.Errorf("failed to get remote origin: %w", err)
+		return
+	}
+
+	g.log.Print("- fetching the full history of ", branch, " in ", dir)
+
+	env, err := gitCLIEnv(remote.Config().URLs[0])
+	if err != nil {
+		return
+	}
+
+	cmd := exec.Command("git", "fetch", "--unshallow", "--tags", "origin", string(fetchRefSpec(branch)))
+	cmd.Dir = dir + ".git"
+	cmd.Stdout = g.log.Writer()
+	cmd.Stderr = g.log.Writer()
+	cmd.Env = append(os.Environ(), env...)
+	return cmd.Run()
+}
+
+func (g gitOps) isShallow(dir string) bool {
+	repo, err := git.PlainOpen(dir + ".git")
+	if err != nil {
+		return false
+	}
+
+	shallow, err := repo.Storer.Shallow()
+	return err == nil && len(shallow) != 0
+}
+
 func (g gitOps) CleanBranch(branch, dir string) (err error) {
 	log := g.log
 
@@ -155,7 +269,7 @@ func (g gitOps) CleanBranch(branch, dir string) (err error) {
 		return
 	}
 
-	ref, err := repo.Reference(plumbing.NewRemoteReferenceName("origin", branch), true)
+	ref, err := resolveRef(repo, branch)
 	if err != nil {
 		return
 	}
@@ -168,12 +282,20 @@ func (g gitOps) CleanBranch(branch, dir string) (err error) {
 		return
 	}
 
-	branchRef := plumbing.NewBranchReferenceName(branch)
-	w.Checkout(&git.CheckoutOptions{
-		Branch: branchRef,
-		Create: true,
-		Force:  true,
-	})
+	if isTagRef(branch) {
+		w.Checkout(&git.CheckoutOptions{
+			Hash:  ref.Hash(),
+			Force: true,
+		})
+	} else {
+		branchRef := plumbing.NewBranchReferenceName(branch)
+		w.Checkout(&git.CheckoutOptions{
+			Branch: branchRef,
+			Hash:   ref.Hash(), // HEAD may not exist yet (ie: partial fetches)
+			Create: true,
+			Force:  true,
+		})
+	}
 
 	w.Reset(&git.ResetOptions{
 		Commit: ref.Hash(),
@@ -208,10 +330,85 @@ func (g gitOps) BranchRef(dir, branch string) (repo *git.Repository, ref *plumbi
 	if err != nil {
 		return
 	}
-	ref, err = repo.Reference(plumbing.NewRemoteReferenceName("origin", branch), true)
+	ref, err = resolveRef(repo, branch)
 	return
 }
 
+// isTagRef returns true if branch is in fact a tag, given as "refs/tags/<name>".
+func isTagRef(branch string) bool {
+	return plumbing.ReferenceName(branch).IsTag()
+}
+
+// fullRefName returns the full reference name of a branch or tag.
+func fullRefName(branch string) string {
+	if isTagRef(branch) {
+		return branch
+	}
+	return plumbing.NewBranchReferenceName(branch).String()
+}
+
+// resolveRef resolves a remote branch, or a tag given as "refs/tags/<name>", to its commit.
+func resolveRef(repo *git.Repository, branch string) (ref *plumbing.Reference, err error) {
+	if !isTagRef(branch) {
+		return repo.Reference(plumbing.NewRemoteReferenceName("origin", branch), true)
+	}
+
+	ref, err = repo.Reference(plumbing.ReferenceName(branch), true)
+	if err != nil {
+		return
+	}
+
+	// peel annotated tags
+	tag, err := repo.TagObject(ref.Hash())
+	if err == plumbing.ErrObjectNotFound {
+		err = nil
+		return
+	} else if err != nil {
+		return
+	}
+
+	commit, err := tag.Commit()
+	if err != nil {
+		err = fmt.Errorf("failed to get tag commit on %s: %w", branch, err)
+		return
+	}
+
+	ref = plumbing.NewHashReference(ref.Name(), commit.Hash)
+	return
+}
+
+func (g gitOps) Commit(dir, branch string) (commit string, err error) {
+	_, ref, err := g.BranchRef(dir, branch)
+	if err != nil {
+		return
+	}
+
+	commit = ref.Hash().String()
+	return
+}
+
+// HeadCommit returns the branch's head commit.
+func (g gitOps) HeadCommit(dir, branch string) (commit *object.Commit, err error) {
+	repo, err := git.PlainOpen(dir + ".git")
+	if err != nil {
+		return
+	}
+
+	ref, err := resolveRef(repo, branch)
+	if err != nil {
+		return
+	}
+
+	return repo.CommitObject(ref.Hash())
+}
+
+func shortCommit(commit string) string {
+	if len(commit) > 7 {
+		return commit[:7]
+	}
+	return commit
+}
+
 func (g gitOps) Tag(dir, branch string) (tag string, err error) {
 	repo, ref, err := g.BranchRef(dir, branch)
 	if err != nil {
@@ -232,7 +429,21 @@ func (g gitOps) Tag(dir, branch string) (tag string, err error) {
 	return
 }
 
+// Describe returns the equivalent of git describe --tags, fetching the full history
+// if the repository is shallow and the history is needed.
 func (g gitOps) Describe(dir, branch string) (describe string, err error) {
+	describe, err = g.describe(dir, branch)
+	if errors.Is(err, plumbing.ErrObjectNotFound) && g.isShallow(dir) {
+		if err = g.Deepen(dir, branch); err != nil {
+			err = fmt.Errorf("failed to deepen history: %w", err)
+			return
+		}
+		describe, err = g.describe(dir, branch)
+	}
+	return
+}
+
+func (g gitOps) describe(dir, branch string) (describe string, err error) {
 	repo, ref, err := g.BranchRef(dir, branch)
 	if err != nil {
 		return
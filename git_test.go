@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+func Example_fetchRefSpec() {
+	fmt.Println(fetchRefSpec("main"))
+	fmt.Println(fetchRefSpec("refs/tags/v1.0.0"))
+
+	// Output:
+	// +refs/heads/main:refs/remotes/origin/main
+	// +refs/tags/v1.0.0:refs/tags/v1.0.0
+}
+
+func Example_gitOps_FetchBranchWith() {
+	dir, cleanup := testDir("fetch-test")
+	defer cleanup()
+
+	git := testGit(dir)
+
+	// source repository with 3 commits on main, and another branch
+	git("init", "-q", "-b", "main", "origin")
+	for i := 1; i <= 3; i++ {
+		os.WriteFile(filepath.Join(dir, "origin", "file"), []byte(fmt.Sprint(i)), 0644)
+		git("-C", "origin", "add", "file")
+		git("-C", "origin", "commit", "-q", "-m", fmt.Sprint("commit ", i))
+	}
+	git("-C", "origin", "branch", "other", "HEAD~1")
+	head := git("-C", "origin", "rev-parse", "HEAD")
+
+	g := gitOps{log.New(os.Stderr, "", 0)}
+	src := filepath.Join(dir, "src")
+
+	err := g.FetchBranchWith("file://"+filepath.Join(dir, "origin"), "main", src, FetchOptions{Depth: 1, SingleBranch: true})
+	if err != nil {
+		panic(err)
+	}
+
+	commit, _ := g.Commit(src, "main")
+	content, _ := os.ReadFile(filepath.Join(src, "file"))
+
+	fmt.Println("on head:", commit == head, "content:", string(content))
+	fmt.Println("shallow:", g.isShallow(src))
+	fmt.Println("commits:", git("--git-dir", src+".git", "rev-list", "--count", "--all"))
+
+	if err = g.Deepen(src, "main"); err != nil {
+		panic(err)
+	}
+	fmt.Println("deepened commits:", git("--git-dir", src+".git", "rev-list", "--count", "--all"))
+
+	// Output:
+	// on head: true content: 3
+	// shallow: true
+	// commits: 1
+	// deepened commits: 3
+}
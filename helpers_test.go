@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// testDir creates a temporary directory, removed by cleanup.
+func testDir(prefix string) (dir string, cleanup func()) {
+	dir, err := os.MkdirTemp("", prefix)
+	if err != nil {
+		panic(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// testGit returns a function running the git CLI in dir with a test identity and the
+// given global options, and returning its trimmed output. It panics on failure.
+func testGit(dir string, options ...string) func(args ...string) string {
+	return func(args ...string) string {
+		gitArgs := []string{"-c", "user.name=test", "-c", "user.email=test@example.org", "-c", "protocol.file.allow=always"}
+		gitArgs = append(gitArgs, options...)
+
+		cmd := exec.Command("git", append(gitArgs, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			panic(fmt.Errorf("git %v: %w: %s", args, err, out))
+		}
+		return strings.TrimSpace(string(out))
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BuildRecord is the record of a build run, stored next to its logs.
+type BuildRecord struct {
+	ID       string
+	Time     time.Time
+	Success  bool
+	Deployed bool
+	Error    string `json:",omitempty"`
+	Skipped  string `json:",omitempty"` // reason the build was skipped
+
+	App    string
+	Build  string // the build's docker name
+	SrcRef string
+	Deploy string // deploy branch
+	Target string // see deployTarget
+
+	SrcCommit     string
+	OverlayCommit string            `json:",omitempty"`
+	Submodules    map[string]string `json:",omitempty"`
+	ImageTag      string
+	Images        []ImageRecord
+
+	// RollbackTo is the ID of the build re-deployed by a rollback, RollbackFrom the image tag it replaced
+	RollbackTo   string `json:",omitempty"`
+	RollbackFrom string `json:",omitempty"`
+	// PromotedFrom is the ID of the build whose images were promoted
+	PromotedFrom string `json:",omitempty"`
+	// RedeployOf is the ID of the build whose images were deployed again
+	RedeployOf string `json:",omitempty"`
+}
+
+type ImageRecord struct {
+	Name   string `json:",omitempty"`
+	Ref    string
+	Digest string `json:",omitempty"`
+}
+
+func (b *BuildRun) record(err error) (record BuildRecord) {
+	record = BuildRecord{
+		ID:      b.id,
+		Time:    time.Now(),
+		Success: err == nil,
+		// deploy updates are the last step of a run
+		Deployed: err == nil && !b.directives.SkipDeploy,
+
+		App:    b.app.Name,
+		Build:  b.build.DockerName(Image{}),
+		SrcRef: b.srcRef,
+		Deploy: b.branch.Deploy,
+		Target: deployTarget(b.app, b.build, b.branch),
+
+		SrcCommit:     b.srcCommit,
+		OverlayCommit: b.overlayCommit,
+		Submodules:    b.submodules,
+		ImageTag:      b.imageTag,
+
+		RollbackTo:   b.rollbackTo,
+		RollbackFrom: b.rollbackFrom,
+		PromotedFrom: b.promotedFrom,
+		RedeployOf:   b.redeployOf,
+	}
+
+	if err != nil {
+		record.Error = err.Error()
+	}
+
+	for _, img := range b.images {
+		record.Images = append(record.Images, ImageRecord{
+			Name:   img.Name,
+			Ref:    img.Ref,
+			Digest: img.Digest,
+		})
+	}
+
+	return
+}
+
+func buildRecordsDir() string {
+	return filepath.Join(*workDir, "builds")
+}
+
+func saveBuildRecord(record BuildRecord) (err error) {
+	dir := buildRecordsDir()
+	if err = os.MkdirAll(dir, 0750); err != nil {
+		return
+	}
+
+	ba, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return
+	}
+
+	// readers must never see a partial record
+	path := filepath.Join(dir, record.ID+".json")
+	if err = os.WriteFile(path+".tmp", ba, 0640); err != nil {
+		return
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// buildRecords caches the records read, by path. Records are never modified once saved.
+var buildRecords = struct {
+	sync.Mutex
+	byPath map[string]BuildRecord
+}{byPath: map[string]BuildRecord{}}
+
+// walkBuildRecords calls fn with each record, most recent first, until it returns false.
+// Unreadable records are logged and skipped.
+func walkBuildRecords(fn func(r BuildRecord) (more bool)) (err error) {
+	entries, err := os.ReadDir(buildRecordsDir())
+	if errors.Is(err, fs.ErrNotExist) {
+		err = nil
+		return
+	} else if err != nil {
+		return
+	}
+
+	// IDs are ULIDs so names sort by time
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() > entries[j].Name() })
+
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		path := filepath.Join(buildRecordsDir(), entry.Name())
+
+		buildRecords.Lock()
+		record, cached := buildRecords.byPath[path]
+		buildRecords.Unlock()
+
+		if !cached {
+			ba, readErr := os.ReadFile(path)
+			if readErr == nil {
+				readErr = json.Unmarshal(ba, &record)
+			}
+			if readErr != nil {
+				log.Print("WARNING: ignoring build record ", path, ": ", readErr)
+				continue
+			}
+
+			buildRecords.Lock()
+			buildRecords.byPath[path] = record
+			buildRecords.Unlock()
+		}
+
+		if !fn(record) {
+			return
+		}
+	}
+
+	return
+}
+
+// findBuildRecords returns the records matching filter, most recent first.
+func findBuildRecords(filter func(r BuildRecord) bool) (records []BuildRecord, err error) {
+	err = walkBuildRecords(func(r BuildRecord) bool {
+		if filter(r) {
+			records = append(records, r)
+		}
+		return true
+	})
+	return
+}
+
+// findLastBuildRecord returns the most recent record matching filter, if any.
+func findLastBuildRecord(filter func(r BuildRecord) bool) (record *BuildRecord, err error) {
+	err = walkBuildRecords(func(r BuildRecord) bool {
+		if filter(r) {
+			record = &r
+			return false
+		}
+		return true
+	})
+	return
+}
+
+// isBuild returns true if the record is a build, not a re-deployment of a previous build's images.
+func (r BuildRecord) isBuild() bool {
+	return r.RollbackTo == "" && r.PromotedFrom == "" && r.RedeployOf == ""
+}
+
+// lastBuild returns the most recent build (even skipped) of the app's build for srcRef.
+func lastBuild(app, build, srcRef string) (record *BuildRecord, err error) {
+	return findLastBuildRecord(func(r BuildRecord) bool {
+		return r.isBuild() && r.App == app && r.Build == build && r.SrcRef == srcRef
+	})
+}
+
+// lastDeployedBuild returns the most recent deployment of srcRef on the target. Builds
+// that were not deployed (ie: [skip deploy]) are ignored.
+func lastDeployedBuild(target, srcRef string) (record *BuildRecord, err error) {
+	return findLastBuildRecord(func(r BuildRecord) bool {
+		return r.Deployed && r.Target == target && r.SrcRef == srcRef
+	})
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+func Example_findLastBuildRecord() {
+	dir, cleanup := testDir("history-test")
+	defer cleanup()
+
+	defer func(prevWorkDir string) { *workDir = prevWorkDir }(*workDir)
+	*workDir = dir
+
+	saveBuildRecord(BuildRecord{ID: "1", SrcRef: "main", Success: true})
+	saveBuildRecord(BuildRecord{ID: "2", SrcRef: "main", Success: false})
+	os.WriteFile(filepath.Join(buildRecordsDir(), "3.json"), []byte("{truncated"), 0640)
+
+	record, err := findLastBuildRecord(func(r BuildRecord) bool { return r.SrcRef == "main" && r.Success })
+	fmt.Println(record.ID, err)
+
+	records, err := findBuildRecords(func(r BuildRecord) bool { return true })
+	fmt.Println(len(records), err)
+
+	// Output:
+	// 1 <nil>
+	// 2 <nil>
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"io"
 	"log"
@@ -9,17 +10,81 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
 )
 
 func setupHTTP() {
 	http.HandleFunc("/webhook", handleWebhook)
 	http.HandleFunc("/build-logs/", handleBuildLog)
+	http.HandleFunc("POST /api/apps/{app}/rollback", handleRollback)
+	http.HandleFunc("POST /api/apps/{app}/promote", handlePromote)
+	http.HandleFunc("POST /api/apps/{app}/redeploy", handleRedeploy)
+	http.HandleFunc("GET /api/apps-config", handleAppsConfig)
+}
+
+// apiAuthorized checks the API request's bearer token against the webhook secret.
+// The API is disabled when no secret is configured.
+func apiAuthorized(w http.ResponseWriter, req *http.Request) bool {
+	if webhookSecret == "" {
+		http.Error(w, "API disabled: WEBHOOK_SECRET is not set", http.StatusForbidden)
+		return false
+	}
+
+	token, _ := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(webhookSecret)) != 1 {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+func handleRollback(w http.ResponseWriter, req *http.Request) {
+	if !apiAuthorized(w, req) {
+		return
+	}
+
+	params := struct {
+		Branch string `json:"branch"`
+		Build  string `json:"build"`
+		To     string `json:"to"`
+	}{}
+
+	if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if params.Branch == "" {
+		http.Error(w, "branch is mandatory", http.StatusBadRequest)
+		return
+	}
+
+	globalLock.Lock()
+	defer globalLock.Unlock()
+
+	b, err := planRollback(req.PathValue("app"), params.Branch, params.Build, params.To)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	record, err := b.Rollback()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(record)
 }
 
 func handleWebhook(w http.ResponseWriter, req *http.Request) {
 	data := &struct {
 		Secret     string `json:"secret"`
 		Ref        string `json:"ref"`
+		After      string `json:"after"`
+		Deleted    bool   `json:"deleted"`
 		Repository struct {
 			FullName string `json:"full_name"`
 			CloneURL string `json:"clone_url"`
@@ -44,26 +109,155 @@ func handleWebhook(w http.ResponseWriter, req *http.Request) {
 		}
 	}
 
-	_, branch, found := strings.Cut(data.Ref, "refs/heads/")
+	ref := plumbing.ReferenceName(data.Ref)
 
-	if !found {
-		log.Print("webhook ignored: ref is not a branch: ", data.Ref)
+	if !ref.IsBranch() && !ref.IsTag() {
+		log.Print("webhook ignored: ref is not a branch or a tag: ", data.Ref)
 		return
 	}
 
+	deleted := refDeleted(data.Deleted, data.After)
+
 	go func() {
 		for _, u := range []string{data.Repository.CloneURL, data.Repository.SshUrl} {
 			if u == "" {
 				continue
 			}
 
-			if triggerFromURL(u, branch) {
+			if triggerFromURL(u, data.Ref, deleted) {
 				break
 			}
 		}
 	}()
 }
 
+// refDeleted returns true if a push event deletes its ref, as told explicitly (ie: gitea,
+// github) or by a null after commit (ie: gitlab).
+func refDeleted(deleted bool, after string) bool {
+	return deleted || (after != "" && strings.Trim(after, "0") == "")
+}
+
+func handlePromote(w http.ResponseWriter, req *http.Request) {
+	if !apiAuthorized(w, req) {
+		return
+	}
+
+	params := struct {
+		From  string `json:"from"`
+		To    string `json:"to"`
+		Build string `json:"build"`
+	}{}
+
+	if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if params.From == "" || params.To == "" {
+		http.Error(w, "from and to are mandatory", http.StatusBadRequest)
+		return
+	}
+
+	globalLock.Lock()
+	defer globalLock.Unlock()
+
+	b, ok, err := planPromotion(req.PathValue("app"), params.Build, params.From, params.To)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !ok {
+		http.Error(w, "already deployed", http.StatusConflict)
+		return
+	}
+
+	record, err := b.Promote(params.From)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(record)
+}
+
+func handleRedeploy(w http.ResponseWriter, req *http.Request) {
+	if !apiAuthorized(w, req) {
+		return
+	}
+
+	params := struct {
+		Branch string `json:"branch"`
+		Build  string `json:"build"`
+	}{}
+
+	if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if params.Branch == "" {
+		http.Error(w, "branch is mandatory", http.StatusBadRequest)
+		return
+	}
+
+	globalLock.Lock()
+	defer globalLock.Unlock()
+
+	app, err := findApp(req.PathValue("app"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	build, branchInfo, err := findDeployBranch(*app, params.Build, params.Branch)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	b, err := planRedeploy(*app, build, branchInfo)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if b == nil {
+		http.Error(w, "nothing deployed to redeploy", http.StatusNotFound)
+		return
+	}
+
+	record, err := b.Redeploy("redeploying", b.redeployMessage("requested"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(record)
+}
+
+// handleAppsConfig tells the active apps config commit, and the failing one if any.
+func handleAppsConfig(w http.ResponseWriter, req *http.Request) {
+	if !apiAuthorized(w, req) {
+		return
+	}
+
+	appsStatus.Lock()
+	ba, err := json.Marshal(map[string]any{
+		"active":  appsStatus.Active,
+		"failing": appsStatus.Failing,
+	})
+	appsStatus.Unlock()
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(ba)
+}
+
 func handleBuildLog(w http.ResponseWriter, req *http.Request) {
 	buildID := path.Base(req.URL.Path)
 
@@ -0,0 +1,16 @@
+package main
+
+import "fmt"
+
+func Example_refDeleted() {
+	fmt.Println(refDeleted(true, ""))
+	fmt.Println(refDeleted(false, "0000000000000000000000000000000000000000"))
+	fmt.Println(refDeleted(false, "4b825dc642cb6eb9a060e54bf8d69288fbee4904"))
+	fmt.Println(refDeleted(false, ""))
+
+	// Output:
+	// true
+	// true
+	// false
+	// false
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// ociTagRegexp is the OCI distribution spec's tag grammar.
+var ociTagRegexp = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9._-]{0,127}$`)
+
+// TagData is given to tag templates.
+type TagData struct {
+	// Default is the tag the builder would produce without template.
+	Default string
+
+	Commit      string
+	ShortCommit string
+	Branch      string // sanitized to be usable in a tag
+	BranchRaw   string
+	Tag         string // git tag of tag builds
+
+	OverlayCommit      string
+	OverlayShortCommit string
+	OverlayBranch      string
+
+	Date    time.Time
+	BuildID string
+	Suffix  string
+
+	g          gitOps
+	srcRef     string
+	srcDir     string
+	overlayDir string
+}
+
+// Describe returns the git describe of the source commit.
+func (d TagData) Describe() (string, error) {
+	return d.g.Describe(d.srcDir, d.srcRef)
+}
+
+// OverlayDescribe returns the git describe of the overlay commit.
+func (d TagData) OverlayDescribe() (string, error) {
+	if d.overlayDir == "" {
+		return "", nil
+	}
+	return d.g.Describe(d.overlayDir, d.OverlayBranch)
+}
+
+func renderImageTag(tmplText string, data TagData) (tag string, err error) {
+	tmpl, err := template.New("tag").Option("missingkey=error").Parse(tmplText)
+	if err != nil {
+		err = fmt.Errorf("failed to parse tag template: %w", err)
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	err = tmpl.Execute(buf, data)
+	if err != nil {
+		err = fmt.Errorf("failed to render tag template: %w", err)
+		return
+	}
+
+	tag = strings.TrimSpace(buf.String())
+	return
+}
+
+func validateImageTag(tag string) error {
+	if !ociTagRegexp.MatchString(tag) {
+		return fmt.Errorf("invalid image tag: %q", tag)
+	}
+	return nil
+}
+
+// sanitizeTag replaces characters not allowed in an image tag.
+func sanitizeTag(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '.', r == '-':
+			return r
+		default:
+			return '-'
+		}
+	}, s)
+
+	s = strings.TrimLeft(s, ".-")
+	if len(s) > 128 {
+		s = s[:128]
+	}
+	return s
+}
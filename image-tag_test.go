@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+func ExampleTagData() {
+	data := TagData{
+		Default:     "abc1234",
+		Commit:      "abc1234def5678abc1234def5678abc1234def56",
+		ShortCommit: "abc1234",
+		Branch:      sanitizeTag("feature/login"),
+		BranchRaw:   "feature/login",
+		Date:        time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC),
+	}
+
+	for _, tmpl := range []string{
+		`{{ .Date.Format "2006.01.02" }}-{{ .Branch }}-{{ .ShortCommit }}`,
+		`{{ .Default }}`,
+		`{{ .BranchRaw }}`,
+	} {
+		tag, err := renderImageTag(tmpl, data)
+		if err == nil {
+			err = validateImageTag(tag)
+		}
+		fmt.Println(tag, err)
+	}
+
+	// Output:
+	// 2026.10.16-feature-login-abc1234 <nil>
+	// abc1234 <nil>
+	// feature/login invalid image tag: "feature/login"
+}
+
+func Example_sanitizeTag() {
+	for _, tag := range []string{"v1.0.0", "release/1.0", "v1.0.0+meta", ".hidden"} {
+		fmt.Println(sanitizeTag(tag))
+	}
+
+	// Output:
+	// v1.0.0
+	// release-1.0
+	// v1.0.0-meta
+	// hidden
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/spf13/pflag"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	lfsPointerVersion = "version https://git-lfs.github.com/spec/v1"
+	lfsMediaType      = "application/vnd.git-lfs+json"
+	lfsMaxPointerSize = 1024
+	// lfsBatchSize is the number of objects per batch request (servers usually limit it to 100)
+	lfsBatchSize = 100
+)
+
+var lfsTimeout time.Duration
+
+func init() {
+	pflag.DurationVar(&lfsTimeout, "lfs-timeout", 10*time.Minute, "timeout of each LFS request, object downloads included")
+}
+
+func lfsClient() *http.Client {
+	return &http.Client{Timeout: lfsTimeout}
+}
+
+type lfsPointer struct {
+	Path string `json:"-"`
+	Oid  string `json:"oid"`
+	Size int64  `json:"size"`
+}
+
+func lfsCacheDir() string {
+	return filepath.Join(*workDir, "lfs", "objects")
+}
+
+func lfsCachePath(oid string) string {
+	return filepath.Join(lfsCacheDir(), oid[0:2], oid[2:4], oid)
+}
+
+// FetchLFS replaces the LFS pointers of the worktree in dir by their content, downloading
+// objects not in the local cache through the LFS batch API.
+func (g gitOps) FetchLFS(repoURL, dir string) (err error) {
+	log := g.log
+
+	pointers, err := findLFSPointers(dir)
+	if err != nil {
+		err = fmt.Errorf("failed to find LFS pointers: %w", err)
+		return
+	}
+
+	if len(pointers) == 0 {
+		return
+	}
+
+	missing := make([]lfsPointer, 0)
+	seen := map[string]bool{}
+	for _, p := range pointers {
+		if seen[p.Oid] {
+			continue
+		}
+		seen[p.Oid] = true
+
+		if _, statErr := os.Stat(lfsCachePath(p.Oid)); statErr != nil {
+			missing = append(missing, p)
+		}
+	}
+
+	log.Printf("- LFS: %d files, %d objects to download", len(pointers), len(missing))
+
+	if len(missing) != 0 {
+		if err = g.downloadLFS(gitURL(repoURL), missing); err != nil {
+			err = fmt.Errorf("failed to download LFS objects: %w", err)
+			return
+		}
+	}
+
+	for _, p := range pointers {
+		if err = smudgeLFS(dir, p); err != nil {
+			err = fmt.Errorf("failed to write LFS file %s: %w", p.Path, err)
+			return
+		}
+	}
+
+	return
+}
+
+func findLFSPointers(dir string) (pointers []lfsPointer, err error) {
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, inErr error) (err error) {
+		if inErr != nil {
+			return inErr
+		}
+
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return
+		}
+
+		if !d.Type().IsRegular() {
+			return
+		}
+
+		info, err := d.Info()
+		if err != nil || info.Size() > lfsMaxPointerSize {
+			return
+		}
+
+		ba, err := os.ReadFile(path)
+		if err != nil {
+			return
+		}
+
+		p, ok := parseLFSPointer(ba)
+		if !ok {
+			return
+		}
+
+		p.Path, err = filepath.Rel(dir, path)
+		if err != nil {
+			return
+		}
+
+		pointers = append(pointers, p)
+		return
+	})
+	return
+}
+
+func parseLFSPointer(ba []byte) (p lfsPointer, ok bool) {
+	if !bytes.HasPrefix(ba, []byte(lfsPointerVersion+"\n")) {
+		return
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(ba))
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), " ")
+		switch key {
+		case "oid":
+			p.Oid, _ = strings.CutPrefix(value, "sha256:")
+		case "size":
+			p.Size, _ = strconv.ParseInt(value, 10, 64)
+		}
+	}
+
+	if len(p.Oid) != 64 {
+		return
+	}
+	if _, err := hex.DecodeString(p.Oid); err != nil {
+		return
+	}
+
+	return p, true
+}
+
+func smudgeLFS(dir string, p lfsPointer) (err error) {
+	target := filepath.Join(dir, p.Path)
+
+	info, err := os.Stat(target)
+	if err != nil {
+		return
+	}
+
+	in, err := os.Open(lfsCachePath(p.Oid))
+	if err != nil {
+		return
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(target, os.O_TRUNC|os.O_WRONLY, info.Mode())
+	if err != nil {
+		return
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	return
+}
+
+type lfsAction struct {
+	Href   string            `json:"href"`
+	Header map[string]string `json:"header"`
+}
+
+type lfsBatchObject struct {
+	Oid     string `json:"oid"`
+	Size    int64  `json:"size"`
+	Actions struct {
+		Download *lfsAction `json:"download"`
+	} `json:"actions"`
+	Error *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (g gitOps) downloadLFS(repoURL string, pointers []lfsPointer) (err error) {
+	endpoint, err := lfsEndpoint(repoURL)
+	if err != nil {
+		return
+	}
+
+	for len(pointers) != 0 {
+		chunk := pointers[:min(len(pointers), lfsBatchSize)]
+		pointers = pointers[len(chunk):]
+
+		var objects []lfsBatchObject
+		if objects, err = lfsBatch(endpoint, chunk); err != nil {
+			return
+		}
+
+		for _, obj := range objects {
+			if obj.Error != nil {
+				return fmt.Errorf("LFS object %s: %d %s", obj.Oid, obj.Error.Code, obj.Error.Message)
+			}
+			if obj.Actions.Download == nil {
+				continue // server says we already have it
+			}
+
+			g.log.Printf("  - LFS: downloading %s (%d bytes)", obj.Oid, obj.Size)
+			if err = downloadLFSObject(obj.Oid, *obj.Actions.Download); err != nil {
+				return fmt.Errorf("LFS object %s: %w", obj.Oid, err)
+			}
+		}
+	}
+
+	return
+}
+
+// lfsBatch asks the server how to download the objects.
+func lfsBatch(server lfsServer, pointers []lfsPointer) (objects []lfsBatchObject, err error) {
+	reqBody, err := json.Marshal(map[string]any{
+		"operation": "download",
+		"transfers": []string{"basic"},
+		"objects":   pointers,
+	})
+	if err != nil {
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodPost, server.Href+"/objects/batch", bytes.NewReader(reqBody))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Accept", lfsMediaType)
+	req.Header.Set("Content-Type", lfsMediaType)
+	server.setAuth(req)
+
+	resp, err := lfsClient().Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("LFS batch request failed: %s", resp.Status)
+		return
+	}
+
+	batch := struct {
+		Objects []lfsBatchObject `json:"objects"`
+	}{}
+
+	if err = json.NewDecoder(resp.Body).Decode(&batch); err != nil {
+		err = fmt.Errorf("invalid LFS batch response: %w", err)
+		return
+	}
+
+	return batch.Objects, nil
+}
+
+func downloadLFSObject(oid string, action lfsAction) (err error) {
+	req, err := http.NewRequest(http.MethodGet, action.Href, nil)
+	if err != nil {
+		return
+	}
+	for k, v := range action.Header {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := lfsClient().Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download failed: %s", resp.Status)
+	}
+
+	target := lfsCachePath(oid)
+	if err = os.MkdirAll(filepath.Dir(target), 0750); err != nil {
+		return
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(target), oid+".*.tmp")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(io.MultiWriter(tmp, h), resp.Body); err != nil {
+		return
+	}
+
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != oid {
+		return fmt.Errorf("checksum mismatch: got %s", sum)
+	}
+
+	if err = tmp.Close(); err != nil {
+		return
+	}
+
+	return os.Rename(tmp.Name(), target)
+}
+
+// lfsServer is an LFS server endpoint, with its authentication.
+type lfsServer struct {
+	Href   string            `json:"href"`
+	Header map[string]string `json:"header"`
+
+	auth transport.AuthMethod
+}
+
+func (s lfsServer) setAuth(req *http.Request) {
+	if len(s.Header) != 0 {
+		for k, v := range s.Header {
+			req.Header.Set(k, v)
+		}
+		return
+	}
+
+	if auth, ok := s.auth.(githttp.AuthMethod); ok {
+		auth.SetAuth(req)
+	}
+}
+
+// lfsEndpoint returns the LFS server of a repository. Like git-lfs, SSH remotes
+// are asked for the endpoint and credentials using git-lfs-authenticate.
+func lfsEndpoint(repoURL string) (server lfsServer, err error) {
+	ep, err := transport.NewEndpoint(repoURL)
+	if err != nil {
+		return
+	}
+
+	path := strings.TrimPrefix(ep.Path, "/")
+
+	switch ep.Protocol {
+	case "http", "https":
+		href := strings.TrimSuffix(repoURL, "/")
+		if !strings.HasSuffix(href, ".git") {
+			href += ".git"
+		}
+		server = lfsServer{Href: href + "/info/lfs", auth: gitAuthFor(repoURL)}
+		return
+
+	case "ssh":
+		sshAuth, ok := gitAuthFor(repoURL).(gitssh.AuthMethod)
+		if !ok {
+			err = fmt.Errorf("no SSH authentication to reach %s", repoURL)
+			return
+		}
+
+		var out []byte
+		out, err = sshRun(ep, sshAuth, "git-lfs-authenticate "+path+" download")
+		if err != nil {
+			err = fmt.Errorf("git-lfs-authenticate failed: %w", err)
+			return
+		}
+
+		if err = json.Unmarshal(out, &server); err != nil {
+			err = fmt.Errorf("invalid git-lfs-authenticate response: %w", err)
+			return
+		}
+		return
+
+	default:
+		err = fmt.Errorf("LFS not supported on %s remotes", ep.Protocol)
+		return
+	}
+}
+
+func sshRun(ep *transport.Endpoint, auth gitssh.AuthMethod, command string) (out []byte, err error) {
+	config, err := auth.ClientConfig()
+	if err != nil {
+		return
+	}
+	config.Timeout = 30 * time.Second
+
+	port := ep.Port
+	if port == 0 {
+		port = 22
+	}
+
+	client, err := ssh.Dial("tcp", net.JoinHostPort(ep.Host, strconv.Itoa(port)), config)
+	if err != nil {
+		return
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return
+	}
+	defer session.Close()
+
+	return session.Output(command)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+)
+
+func Example_parseLFSPointer() {
+	for _, content := range []string{
+		"version https://git-lfs.github.com/spec/v1\noid sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393\nsize 12345\n",
+		"version https://git-lfs.github.com/spec/v1\noid sha256:4d7a21\nsize 12345\n",
+		"oid sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393\nsize 12345\n",
+	} {
+		if p, ok := parseLFSPointer([]byte(content)); ok {
+			fmt.Println(p.Oid, p.Size)
+		} else {
+			fmt.Println("not a pointer")
+		}
+	}
+
+	// Output:
+	// 4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393 12345
+	// not a pointer
+	// not a pointer
+}
+
+func Example_gitOps_downloadLFS() {
+	dir, cleanup := testDir("lfs-test")
+	defer cleanup()
+
+	defer func(prevWorkDir string) { *workDir = prevWorkDir }(*workDir)
+	*workDir = dir
+
+	contents := map[string]string{} // by oid
+	pointers := make([]lfsPointer, 0)
+	for i := 0; i < 150; i++ {
+		content := fmt.Sprint("object ", i)
+		sum := sha256.Sum256([]byte(content))
+		oid := hex.EncodeToString(sum[:])
+
+		contents[oid] = content
+		pointers = append(pointers, lfsPointer{Oid: oid, Size: int64(len(content))})
+	}
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if oid, ok := strings.CutPrefix(req.URL.Path, "/objects/"); ok {
+			w.Write([]byte(contents[oid]))
+			return
+		}
+
+		if req.Method != http.MethodPost || req.URL.Path != "/team/app.git/info/lfs/objects/batch" {
+			http.NotFound(w, req)
+			return
+		}
+
+		batch := struct {
+			Operation string
+			Transfers []string
+			Objects   []map[string]any
+		}{}
+		json.NewDecoder(req.Body).Decode(&batch)
+
+		fmt.Println(req.Header.Get("Content-Type"), batch.Operation, batch.Transfers, len(batch.Objects), "objects with", len(batch.Objects[0]), "fields")
+
+		objects := make([]map[string]any, 0, len(batch.Objects))
+		for _, obj := range batch.Objects {
+			obj["actions"] = map[string]any{"download": map[string]any{"href": srv.URL + "/objects/" + obj["oid"].(string)}}
+			objects = append(objects, obj)
+		}
+
+		w.Header().Set("Content-Type", lfsMediaType)
+		json.NewEncoder(w).Encode(map[string]any{"objects": objects})
+	}))
+	defer srv.Close()
+
+	g := gitOps{log.New(io.Discard, "", 0)}
+	if err := g.downloadLFS(srv.URL+"/team/app", pointers); err != nil {
+		panic(err)
+	}
+
+	cached := 0
+	for oid, content := range contents {
+		if ba, err := os.ReadFile(lfsCachePath(oid)); err == nil && string(ba) == content {
+			cached++
+		}
+	}
+	fmt.Println(cached, "objects cached")
+
+	// Output:
+	// application/vnd.git-lfs+json download [basic] 100 objects with 2 fields
+	// application/vnd.git-lfs+json download [basic] 50 objects with 2 fields
+	// 150 objects cached
+}
@@ -8,6 +8,7 @@ import (
 	"net/url"
 	"os"
 	"os/exec"
+	"path"
 	"strings"
 	"sync"
 
@@ -33,7 +34,7 @@ func main() {
 	bind := pflag.String("bind", ":80", "HTTP bind for triggers")
 	pflag.Parse()
 
-	if args := pflag.Args(); len(args) != 0 {
+	if args := pflag.Args(); len(args) != 0 && args[0] != "rollback" {
 		for _, arg := range args {
 			log.Printf("processing arg: %q", arg)
 			switch arg {
@@ -51,8 +52,18 @@ func main() {
 		log.Fatal("--apps-repo is mandatory")
 	}
 
-	if gitAuth == nil {
-		log.Print("WARNING: no git authentication defined (env GIT_TOKEN or GIT_USER and GIT_PASSWORD)")
+	if gitCredentialsFile != "" {
+		if err = loadGitCredentials(gitCredentialsFile); err != nil {
+			log.Fatal("failed to load git credentials: ", err)
+		}
+	}
+
+	if err = setupSSH(); err != nil {
+		log.Fatal("failed to setup SSH: ", err)
+	}
+
+	if gitAuth == nil && len(gitCredentials) == 0 {
+		log.Print("WARNING: no git authentication defined (env GIT_TOKEN or GIT_USER and GIT_PASSWORD, --ssh-key-file or --git-credentials)")
 	}
 
 	updateApps()
@@ -62,14 +73,26 @@ func main() {
 		log.Fatal("failed to create work dir: ", err)
 	}
 
+	if args := pflag.Args(); len(args) != 0 {
+		rollbackCmd(args[1:])
+		return
+	}
+
 	if *triggerGit != "" {
 		// single trigger run mode
-		triggerFromURL(*triggerGit, *triggerBranch)
+		ref := *triggerBranch
+		if !strings.HasPrefix(ref, "refs/") {
+			ref = "refs/heads/" + ref
+		}
+		triggerFromURL(*triggerGit, ref, false)
 		return
 	}
 
 	setupHTTP()
 
+	go pruneBuilderCacheLoop()
+	go pollLoop()
+
 	log.Print("listening on ", *bind)
 	err = http.ListenAndServe(*bind, nil)
 	if err != nil {
@@ -79,27 +102,56 @@ func main() {
 
 var globalLock = sync.Mutex{}
 
-func triggerFromURL(u, branch string) (ok bool) {
+func triggerFromURL(u, ref string, deleted bool) (ok bool) {
 	log.Print("trigger from URL: ", u)
 
-	repo, ok := cutAllowedPrefix(u)
-	if !ok {
-		log.Printf("trigger ignored: prefix not allowed")
-		return
-	}
-
-	repo = strings.TrimSuffix(repo, ".git")
+	repo := newTriggerRepo(u)
 
-	log.Print("trigger: ", repo, " branch ", branch)
-	triggerFrom(repo, branch)
-	return true
+	if deleted {
+		log.Print("trigger: ", repo, " ref ", ref, " deleted")
+	} else {
+		log.Print("trigger: ", repo, " ref ", ref)
+	}
+	return triggerFrom(repo, ref, deleted)
 }
 
-func triggerFrom(repo, branch string) {
+func triggerFrom(repo triggerRepo, ref string, deleted bool) (ok bool) {
 	globalLock.Lock()
 	defer globalLock.Unlock()
 
-	if repo == appsRepo.Repo && branch == appsRepo.Branch {
+	return triggerLocked(repo, ref, deleted)
+}
+
+// triggerLocked is triggerFrom for callers holding globalLock.
+func triggerLocked(repo triggerRepo, ref string, deleted bool) (ok bool) {
+	if repo.Name == "" && !repo.known(currentProject) {
+		log.Printf("trigger ignored: prefix not allowed and repository not referenced by apps")
+		return
+	}
+
+	ok = true
+
+	if deleted {
+		if branch, ok := strings.CutPrefix(ref, "refs/heads/"); ok {
+			teardownPreviews(repo, branch)
+		} else {
+			log.Print("trigger ignored: deleted ref is not a branch: ", ref)
+		}
+		return
+	}
+
+	if branch, ok := strings.CutPrefix(ref, "refs/heads/"); ok {
+		triggerFromBranch(repo, branch)
+	} else if tag, ok := strings.CutPrefix(ref, "refs/tags/"); ok {
+		triggerFromTag(repo, tag)
+	} else {
+		log.Print("trigger ignored: ref is not a branch or a tag: ", ref)
+	}
+	return
+}
+
+func triggerFromBranch(repo triggerRepo, branch string) {
+	if repo.Is(appsRepo.Repo) && branch == appsRepo.Branch {
 		updateApps()
 	}
 
@@ -107,37 +159,90 @@ func triggerFrom(repo, branch string) {
 
 	for _, app := range config.apps {
 		for _, build := range app.Builds {
-			branches := make([]*BranchInfo, 0)
+			runs := make([]*BuildRun, 0)
 
-			switch repo {
-			case build.Source:
+			switch {
+			case repo.Is(build.Source):
 				for _, b := range build.Branches {
-					if b.Source == branch {
-						log.Print("- matched build ", app.Name, " repo ", build.Source, ", branch ", branch)
-						log.Print("  - matched branch ", branch)
-						branches = append(branches, b)
+					if b.SourceTags != "" {
+						continue
+					}
+
+					vars, ok := b.Match(branch)
+					if !ok {
+						continue
 					}
+
+					log.Print("- matched build ", app.Name, " repo ", build.Source, ", branch ", branch)
+					log.Print("  - matched branch ", b.Source)
+					runs = append(runs, &BuildRun{
+						app:    app,
+						build:  build,
+						branch: b.Expand(branch, vars),
+						srcRef: branch,
+						vars:   vars,
+
+						srcTriggered: true,
+					})
 				}
 
-			case build.Overlay:
+			case repo.Is(build.Overlay):
 				for _, b := range build.Branches {
+					if b.SourceTags != "" || b.IsPattern() {
+						continue
+					}
+
 					if b.Overlay == branch {
 						log.Print("- matched build ", app.Name, " repo ", build.Source,
 							" via overlay (", build.Overlay, "), branch ", branch)
-						branches = append(branches, b)
+						runs = append(runs, &BuildRun{
+							app:    app,
+							build:  build,
+							branch: b,
+							srcRef: b.Source,
+						})
 					}
 				}
 			}
 
-			if len(branches) == 0 {
+			for _, run := range runs {
+				run.Run()
+			}
+		}
+	}
+}
+
+// triggerFromTag runs the builds of branches with source_tags matching the tag.
+func triggerFromTag(repo triggerRepo, tag string) {
+	config := currentProject
+
+	for _, app := range config.apps {
+		for _, build := range app.Builds {
+			if !repo.Is(build.Source) {
 				continue
 			}
 
-			for _, branch := range branches {
+			for _, branch := range build.Branches {
+				if branch.SourceTags == "" {
+					continue
+				}
+
+				if match, err := path.Match(branch.SourceTags, tag); err != nil {
+					log.Printf("- invalid source_tags %q in build %s: %v", branch.SourceTags, app.Name, err)
+					continue
+				} else if !match {
+					continue
+				}
+
+				log.Print("- matched build ", app.Name, " repo ", build.Source, ", tag ", tag)
+
 				run := &BuildRun{
 					app:    app,
 					build:  build,
 					branch: branch,
+					srcRef: "refs/tags/" + tag,
+
+					srcTriggered: true,
 				}
 				run.Run()
 			}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// HasPathFilters returns true if the build only runs for changes in some paths.
+func (b Build) HasPathFilters() bool {
+	return len(b.Paths) != 0 || len(b.IgnorePaths) != 0
+}
+
+// Affected returns true if a change of one of the paths is relevant to the build.
+// Globs are matched against the full path, "**" matching any number of directories.
+func (b Build) Affected(paths []string) (affected bool, err error) {
+	include, err := compileGlobs(b.Paths)
+	if err != nil {
+		return
+	}
+	exclude, err := compileGlobs(b.IgnorePaths)
+	if err != nil {
+		return
+	}
+
+	for _, path := range paths {
+		if len(include) != 0 && !matchAny(include, path) {
+			continue
+		}
+		if matchAny(exclude, path) {
+			continue
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+func compileGlobs(globs []string) (res []*regexp.Regexp, err error) {
+	res = make([]*regexp.Regexp, 0, len(globs))
+	for _, glob := range globs {
+		re, compileErr := regexp.Compile(globRegexp(glob))
+		if compileErr != nil {
+			err = fmt.Errorf("invalid glob %q: %w", glob, compileErr)
+			return
+		}
+		res = append(res, re)
+	}
+	return
+}
+
+func matchAny(res []*regexp.Regexp, s string) bool {
+	for _, re := range res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPathFilters returns a reason to skip the build if no path relevant to the
+// build changed since the last deployed build of the same target.
+func (b *BuildRun) checkPathFilters(g gitOps) (skip string, err error) {
+	last, err := lastDeployedBuild(deployTarget(b.app, b.build, b.branch), b.srcRef)
+	if err != nil || last == nil {
+		return
+	}
+
+	if last.OverlayCommit != b.overlayCommit {
+		return // overlay changed
+	}
+
+	if last.SrcCommit == b.srcCommit {
+		return // rebuild of the same commit, don't skip
+	}
+
+	paths, err := g.ChangedPaths(b.srcDir, last.SrcCommit, b.srcCommit)
+	if err != nil {
+		g.log.Print("- can't check path filters, not skipping: ", err)
+		err = nil
+		return
+	}
+
+	affected, err := b.build.Affected(paths)
+	if err != nil || affected {
+		return
+	}
+
+	skip = fmt.Sprintf("no relevant change since %s (%d paths changed)", shortCommit(last.SrcCommit), len(paths))
+	return
+}
+
+// ChangedPaths returns the paths changed between two commits.
+func (g gitOps) ChangedPaths(dir, from, to string) (paths []string, err error) {
+	repo, err := git.PlainOpen(dir + ".git")
+	if err != nil {
+		return
+	}
+
+	fromCommit, err := repo.CommitObject(plumbing.NewHash(from))
+	if err != nil {
+		err = fmt.Errorf("failed to get commit %s: %w", from, err)
+		return
+	}
+	toCommit, err := repo.CommitObject(plumbing.NewHash(to))
+	if err != nil {
+		err = fmt.Errorf("failed to get commit %s: %w", to, err)
+		return
+	}
+
+	fromTree, err := fromCommit.Tree()
+	if err != nil {
+		return
+	}
+	toTree, err := toCommit.Tree()
+	if err != nil {
+		return
+	}
+
+	changes, err := fromTree.Diff(toTree)
+	if err != nil {
+		return
+	}
+
+	for _, change := range changes {
+		for _, name := range []string{change.From.Name, change.To.Name} {
+			if name != "" {
+				paths = append(paths, name)
+			}
+		}
+	}
+	return
+}
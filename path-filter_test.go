@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+func ExampleBuild_Affected() {
+	build := Build{
+		Paths:       []string{"src/**", "Dockerfile", "*.mod"},
+		IgnorePaths: []string{"**/*.md", "src/testdata/**"},
+	}
+
+	for _, paths := range [][]string{
+		{"src/main.go"},
+		{"src/README.md", "docs/index.md"},
+		{"src/testdata/fixture.json"},
+		{"go.mod"},
+		{"deploy/go.mod"},
+		{"README.md", "Dockerfile"},
+	} {
+		affected, err := build.Affected(paths)
+		fmt.Println(paths, affected, err)
+	}
+
+	// Output:
+	// [src/main.go] true <nil>
+	// [src/README.md docs/index.md] false <nil>
+	// [src/testdata/fixture.json] false <nil>
+	// [go.mod] true <nil>
+	// [deploy/go.mod] false <nil>
+	// [README.md Dockerfile] true <nil>
+}
+
+func Example_gitOps_ChangedPaths() {
+	dir, cleanup := testDir("path-filter-test")
+	defer cleanup()
+
+	// the builder's layout: the repository of the src worktree is in src.git
+	src := filepath.Join(dir, "src")
+
+	git := testGit(dir, "--git-dir", src+".git", "--work-tree", src)
+	commit := func(files map[string]string, removed ...string) string {
+		for file, content := range files {
+			os.MkdirAll(filepath.Join(src, filepath.Dir(file)), 0755)
+			os.WriteFile(filepath.Join(src, file), []byte(content), 0644)
+		}
+		for _, file := range removed {
+			os.Remove(filepath.Join(src, file))
+		}
+		git("add", "-A")
+		git("commit", "-q", "-m", "change")
+		return git("rev-parse", "HEAD")
+	}
+
+	os.MkdirAll(src, 0755)
+	git("init", "-q")
+
+	from := commit(map[string]string{"src/main.go": "v1", "docs/index.md": "v1", "old.txt": "v1"})
+	docsOnly := commit(map[string]string{"docs/index.md": "v2"}, "old.txt")
+	code := commit(map[string]string{"src/main.go": "v2"})
+
+	g := gitOps{log.New(os.Stderr, "", 0)}
+
+	build := Build{Paths: []string{"src/**"}}
+
+	for _, to := range []string{docsOnly, code} {
+		paths, err := g.ChangedPaths(src, from, to)
+		if err != nil {
+			panic(err)
+		}
+		affected, _ := build.Affected(paths)
+		fmt.Println(paths, affected)
+	}
+
+	// Output:
+	// [docs/index.md docs/index.md old.txt] false
+	// [docs/index.md docs/index.md old.txt src/main.go src/main.go] true
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/spf13/pflag"
+)
+
+var pollInterval time.Duration
+
+func init() {
+	pflag.DurationVar(&pollInterval, "poll-interval", 0, "interval between polls of the repositories, as a webhook fallback (0 to disable)")
+}
+
+// poller triggers builds of refs that moved without a webhook.
+type poller struct {
+	// seen are the ref hashes seen by the previous polls, by repository URL
+	seen map[string]map[string]string
+}
+
+func pollLoop() {
+	if pollInterval == 0 {
+		return
+	}
+
+	p := poller{seen: map[string]map[string]string{}}
+	for {
+		time.Sleep(pollInterval)
+		p.poll()
+	}
+}
+
+func (p poller) poll() {
+	globalLock.Lock()
+	urls := pollURLs(currentProject)
+	globalLock.Unlock()
+
+	for _, url := range urls {
+		refs, err := lsRemote(url)
+		if err != nil {
+			log.Print("poll: ls-remote ", url, " failed: ", err)
+			continue
+		}
+
+		seen, known := p.seen[url]
+		p.seen[url] = refs
+
+		triggerMoved(newTriggerRepo(url), refs, seen, known)
+	}
+}
+
+// triggerMoved triggers the builds of the refs that moved. globalLock is held from the check
+// to the builds, so a build running when the refs were listed is not run a second time.
+func triggerMoved(repo triggerRepo, refs, seen map[string]string, known bool) {
+	globalLock.Lock()
+	defer globalLock.Unlock()
+
+	lastBuilds, err := lastBuildsByRef()
+	if err != nil {
+		log.Print("poll: failed to read build history: ", err)
+		return
+	}
+
+	for ref, hash := range refs {
+		if known && seen[ref] == hash {
+			continue // nothing new since the last poll
+		}
+
+		if !refMoved(currentProject, appsCommit, lastBuilds, repo, ref, hash, known) {
+			continue
+		}
+
+		log.Print("poll: ", repo, " ", ref, " moved to ", shortCommit(hash))
+		triggerLocked(repo, ref, false)
+
+		if lastBuilds, err = lastBuildsByRef(); err != nil {
+			log.Print("poll: failed to read build history: ", err)
+			return
+		}
+	}
+}
+
+// lastBuildsByRef returns the last build of each app, build and source ref. Re-deployments keep
+// the commits of the build they deploy, so they don't tell what the ref was last built on.
+func lastBuildsByRef() (lastBuilds map[string]BuildRecord, err error) {
+	records, err := findBuildRecords(func(r BuildRecord) bool { return r.isBuild() })
+	if err != nil {
+		return
+	}
+
+	lastBuilds = map[string]BuildRecord{}
+	for _, r := range records {
+		key := r.App + ":" + r.Build + ":" + r.SrcRef
+		if _, ok := lastBuilds[key]; !ok {
+			lastBuilds[key] = r
+		}
+	}
+	return
+}
+
+// pollURLs returns the URLs of the repositories referenced by the project.
+func pollURLs(project Project) (urls []string) {
+	seen := map[string]bool{}
+	add := func(repo string) {
+		if repo == "" {
+			return
+		}
+		url := gitURL(repo)
+		if key := canonicalRepo(url); !seen[key] {
+			seen[key] = true
+			urls = append(urls, url)
+		}
+	}
+
+	add(appsRepo.Repo)
+	for _, app := range project.apps {
+		for _, build := range app.Builds {
+			add(build.Source)
+			add(build.Overlay)
+		}
+	}
+	return
+}
+
+func lsRemote(url string) (refs map[string]string, err error) {
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: "origin",
+		URLs: []string{url},
+	})
+
+	list, err := remote.List(&git.ListOptions{Auth: gitAuthFor(url)})
+	if err != nil {
+		return
+	}
+
+	refs = map[string]string{}
+	for _, ref := range list {
+		name := ref.Name()
+		if !name.IsBranch() && !name.IsTag() {
+			continue
+		}
+		refs[name.String()] = ref.Hash().String()
+	}
+	return
+}
+
+// refMoved returns true if ref is not built on hash. Never built pattern branches and tags are
+// only considered when they appeared since the previous poll (known), to not build them all on start.
+func refMoved(project Project, appsHead string, lastBuilds map[string]BuildRecord, repo triggerRepo, ref, hash string, known bool) bool {
+	if tag, ok := strings.CutPrefix(ref, "refs/tags/"); ok {
+		if !known {
+			return false
+		}
+
+		for _, app := range project.apps {
+			for _, build := range app.Builds {
+				if !repo.Is(build.Source) {
+					continue
+				}
+
+				for _, branchInfo := range build.Branches {
+					if match, _ := path.Match(branchInfo.SourceTags, tag); branchInfo.SourceTags == "" || !match {
+						continue
+					}
+					if _, built := lastBuilds[app.Name+":"+build.DockerName(Image{})+":"+ref]; !built {
+						return true
+					}
+				}
+			}
+		}
+		return false
+	}
+
+	branch := strings.TrimPrefix(ref, "refs/heads/")
+
+	if repo.Is(appsRepo.Repo) && branch == appsRepo.Branch && hash != appsHead {
+		return true
+	}
+
+	for _, app := range project.apps {
+		for _, build := range app.Builds {
+			buildKey := app.Name + ":" + build.DockerName(Image{}) + ":"
+
+			for _, branchInfo := range build.Branches {
+				if branchInfo.SourceTags != "" {
+					continue
+				}
+
+				if repo.Is(build.Source) {
+					if _, ok := branchInfo.Match(branch); !ok {
+						continue
+					}
+
+					last, built := lastBuilds[buildKey+branch]
+					if !built && branchInfo.IsPattern() && !known {
+						continue
+					}
+					if last.SrcCommit != hash {
+						return true
+					}
+				}
+
+				if repo.Is(build.Overlay) && !branchInfo.IsPattern() && branchInfo.Overlay == branch {
+					if last, built := lastBuilds[buildKey+branchInfo.Source]; !built || last.OverlayCommit != hash {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
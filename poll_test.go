@@ -0,0 +1,67 @@
+package main
+
+import "fmt"
+
+func Example_refMoved() {
+	defer func(prev RepoRef) { appsRepo = prev }(appsRepo)
+	appsRepo = RepoRef{Repo: "apps", Branch: "main"}
+
+	project := Project{apps: []App{{Name: "web", Builds: []Build{{
+		Source:  "web",
+		Overlay: "web-overlay",
+		Branches: []*BranchInfo{
+			{Source: "main", Deploy: "production", Overlay: "production"},
+			{Source: "feature/*", Deploy: "preview-${1}"},
+			{SourceTags: "v*", Deploy: "release"},
+		},
+	}}}}}
+
+	lastBuilds := map[string]BuildRecord{
+		"web:web:main":         {SrcCommit: "a1", OverlayCommit: "o1"},
+		"web:web:feature/old":  {SrcCommit: "f1"},
+		"web:web:refs/tags/v1": {SrcCommit: "t1"},
+	}
+
+	for _, c := range []struct {
+		repo, ref, hash string
+		known           bool
+	}{
+		{"web", "refs/heads/main", "a1", false},
+		{"web", "refs/heads/main", "a2", false},
+		{"web", "refs/heads/feature/old", "f1", false},
+		{"web", "refs/heads/feature/new", "f2", false},
+		{"web", "refs/heads/feature/new", "f2", true},
+		{"web", "refs/heads/other", "x1", true},
+		{"web", "refs/tags/v1", "t1", true},
+		{"web", "refs/tags/v2", "t2", false},
+		{"web", "refs/tags/v2", "t2", true},
+		{"web", "refs/tags/beta", "t3", true},
+		{"web-overlay", "refs/heads/production", "o1", false},
+		{"web-overlay", "refs/heads/production", "o2", false},
+		{"web-overlay", "refs/heads/feature/new", "o3", true},
+		{"apps", "refs/heads/main", "h1", false},
+		{"apps", "refs/heads/main", "h2", false},
+		{"apps", "refs/heads/other", "h3", true},
+	} {
+		moved := refMoved(project, "h1", lastBuilds, triggerRepo{Name: c.repo}, c.ref, c.hash, c.known)
+		fmt.Println(c.repo, c.ref, c.hash, c.known, moved)
+	}
+
+	// Output:
+	// web refs/heads/main a1 false false
+	// web refs/heads/main a2 false true
+	// web refs/heads/feature/old f1 false false
+	// web refs/heads/feature/new f2 false false
+	// web refs/heads/feature/new f2 true true
+	// web refs/heads/other x1 true false
+	// web refs/tags/v1 t1 true false
+	// web refs/tags/v2 t2 false false
+	// web refs/tags/v2 t2 true true
+	// web refs/tags/beta t3 true false
+	// web-overlay refs/heads/production o1 false false
+	// web-overlay refs/heads/production o2 false true
+	// web-overlay refs/heads/feature/new o3 true false
+	// apps refs/heads/main h1 false false
+	// apps refs/heads/main h2 false true
+	// apps refs/heads/other h3 true false
+}
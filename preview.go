@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// setupPreview creates the preview's directory from its template if it doesn't exist yet,
+// expanding variables in file names and contents.
+func (b *BuildRun) setupPreview(deployDir string) (err error) {
+	log := b.log
+	preview := b.branch.Preview
+
+	if !filepath.IsLocal(preview.Dir) || !filepath.IsLocal(preview.Template) {
+		return fmt.Errorf("preview dir %q and template %q must be local to the deploy repository", preview.Dir, preview.Template)
+	}
+
+	targetDir := filepath.Join(deployDir, preview.Dir)
+	if _, err = os.Stat(targetDir); err == nil {
+		return // already exists
+	} else if !os.IsNotExist(err) {
+		return
+	}
+
+	templateDir := filepath.Join(deployDir, preview.Template)
+
+	log.Print("- creating preview ", preview.Dir, " from ", preview.Template)
+	return filepath.WalkDir(templateDir, func(srcPath string, d fs.DirEntry, inErr error) (err error) {
+		if inErr != nil {
+			return inErr
+		}
+
+		path, err := filepath.Rel(templateDir, srcPath)
+		if err != nil {
+			return
+		}
+
+		targetPath := filepath.Join(targetDir, b.expandVars(path))
+
+		if d.IsDir() {
+			return os.MkdirAll(targetPath, 0755)
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return
+		}
+
+		ba, err := os.ReadFile(srcPath)
+		if err != nil {
+			return
+		}
+
+		log.Print("  - ", filepath.Join(preview.Dir, path))
+		return os.WriteFile(targetPath, []byte(b.expandVars(string(ba))), info.Mode())
+	})
+}
+
+// teardownPreviews removes the previews of the deleted branch.
+func teardownPreviews(repo triggerRepo, branch string) {
+	for _, app := range currentProject.apps {
+		for _, build := range app.Builds {
+			if !repo.Is(build.Source) {
+				continue
+			}
+
+			for _, b := range build.Branches {
+				if b.Preview == nil || b.SourceTags != "" {
+					continue
+				}
+
+				vars, ok := b.Match(branch)
+				if !ok {
+					continue
+				}
+
+				teardownPreview(app, build, b.Expand(branch, vars))
+			}
+		}
+	}
+}
+
+func teardownPreview(app App, build Build, branchInfo *BranchInfo) (err error) {
+	preview := branchInfo.Preview
+
+	log := log.New(log.Writer(), "teardown "+app.Name+" preview "+preview.Dir+": ", log.Flags()|log.Lmsgprefix)
+
+	notifPrefix := fmt.Sprint("removing preview ", preview.Dir, " of ", app.Name, "/", build.Source, " (branch ", branchInfo.Source, " deleted)")
+
+	defer func() {
+		if err != nil {
+			notify(notifPrefix + " failed: " + err.Error())
+		} else {
+			notify(notifPrefix + " successful")
+		}
+	}()
+
+	if !filepath.IsLocal(preview.Dir) {
+		return fmt.Errorf("preview dir %q must be local to the deploy repository", preview.Dir)
+	}
+
+	g := gitOps{log}
+
+	deployDir := filepath.Join(*workDir, app.Name, "deploy")
+	if err = g.FetchBranch(app.Deploy, branchInfo.Deploy, deployDir); err != nil {
+		return
+	}
+
+	log.Print("- removing ", preview.Dir)
+	if err = os.RemoveAll(filepath.Join(deployDir, preview.Dir)); err != nil {
+		return
+	}
+
+	_, err = g.CommitAndPush(deployDir, branchInfo.Deploy,
+		"auto-commit: app "+app.Name+": "+build.Source+": remove preview "+preview.Dir+" (branch "+branchInfo.Source+" deleted)", commitAuthor(app))
+	if err != nil {
+		return
+	}
+
+	if err := recordDeployed(deployTarget(app, build, branchInfo), nil); err != nil {
+		log.Print("WARNING: failed to forget deployed images: ", err)
+	}
+	return
+}
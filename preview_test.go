@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+func Example_teardownPreviews() {
+	dir, cleanup := testDir("preview-test")
+	defer cleanup()
+
+	defer func(prevWorkDir string, prevProject Project) {
+		*workDir, currentProject = prevWorkDir, prevProject
+	}(*workDir, currentProject)
+	*workDir = filepath.Join(dir, "work")
+
+	git := testGit(dir)
+
+	// deploy repository with the previews of 2 branches
+	remote := filepath.Join(dir, "deploy.git")
+	git("init", "-q", "--bare", "-b", "previews", remote)
+	git("init", "-q", "-b", "previews", "src")
+	for _, file := range []string{"template/app.yaml", "previews/login/app.yaml", "previews/signup/app.yaml"} {
+		os.MkdirAll(filepath.Join(dir, "src", filepath.Dir(file)), 0755)
+		os.WriteFile(filepath.Join(dir, "src", file), []byte("image: web\n"), 0644)
+	}
+	git("-C", "src", "add", ".")
+	git("-C", "src", "commit", "-q", "-m", "previews")
+	git("-C", "src", "push", "-q", remote, "previews")
+
+	currentProject = Project{apps: []App{{
+		Name:   "web",
+		Deploy: "file://" + remote,
+		Builds: []Build{{
+			Source: "web",
+			Branches: []*BranchInfo{{
+				Source:  "feature/*",
+				Deploy:  "previews",
+				Preview: &Preview{Template: "template", Dir: "previews/${1}"},
+			}},
+		}},
+	}}}
+
+	teardownPreviews(triggerRepo{Name: "other"}, "feature/signup")
+	teardownPreviews(triggerRepo{Name: "web"}, "feature/login")
+
+	fmt.Println(git("--git-dir", remote, "ls-tree", "-r", "--name-only", "previews"))
+	fmt.Println(git("--git-dir", remote, "log", "-1", "--format=%s", "previews"))
+
+	// Output:
+	// previews/signup/app.yaml
+	// template/app.yaml
+	// auto-commit: app web: web: remove preview previews/login (branch feature/login deleted)
+}
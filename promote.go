@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Promotion automatically promotes the images deployed by a branch to another one.
+type Promotion struct {
+	// To is the deploy branch of the branch config promoted to.
+	To string
+	// After is the delay between the deployment and its promotion. The promotion is
+	// cancelled if another deployment happens in the meantime.
+	After time.Duration
+}
+
+// findDeployBranch returns the build and (non-pattern) branch config of the app deploying
+// to deployBranch. buildName (the build's docker name) is needed when several builds match.
+func findDeployBranch(app App, buildName, deployBranch string) (build Build, branchInfo *BranchInfo, err error) {
+	found := 0
+	for _, b := range app.Builds {
+		if buildName != "" && b.DockerName(Image{}) != buildName {
+			continue
+		}
+
+		for _, bi := range b.Branches {
+			if bi.IsPattern() || bi.Deploy != deployBranch {
+				continue
+			}
+
+			build, branchInfo = b, bi
+			found++
+		}
+	}
+
+	switch found {
+	case 0:
+		err = fmt.Errorf("no build of app %s deploys to branch %s", app.Name, deployBranch)
+	case 1:
+	default:
+		err = fmt.Errorf("several builds of app %s deploy to branch %s, please specify the build", app.Name, deployBranch)
+	}
+	return
+}
+
+// lastDeployment returns the most recent deployment on the target.
+func lastDeployment(target string) (record *BuildRecord, err error) {
+	return findLastBuildRecord(func(r BuildRecord) bool {
+		return r.Deployed && r.Target == target
+	})
+}
+
+// planPromotion prepares the deployment of the images last deployed on the app's
+// deploy branch from to its deploy branch to. ok is false if they are already deployed.
+func planPromotion(appName, buildName, from, to string) (b *BuildRun, ok bool, err error) {
+	app, err := findApp(appName)
+	if err != nil {
+		return
+	}
+
+	build, fromBranch, err := findDeployBranch(*app, buildName, from)
+	if err != nil {
+		return
+	}
+
+	// promoted images must be the same build's
+	_, toBranch, err := findDeployBranch(*app, build.DockerName(Image{}), to)
+	if err != nil {
+		return
+	}
+
+	record, err := lastDeployment(deployTarget(*app, build, fromBranch))
+	if err != nil {
+		err = fmt.Errorf("failed to read build history: %w", err)
+		return
+	}
+	if record == nil {
+		err = fmt.Errorf("nothing deployed on branch %s of app %s", from, appName)
+		return
+	}
+
+	current, err := lastDeployment(deployTarget(*app, build, toBranch))
+	if err != nil {
+		err = fmt.Errorf("failed to read build history: %w", err)
+		return
+	}
+	if current != nil && current.ImageTag == record.ImageTag {
+		return
+	}
+
+	b = redeployRun(*app, build, toBranch, nil, *record)
+	b.promotedFrom = record.ID
+	return b, true, nil
+}
+
+// Promote applies the deploy updates of the branch promoted to with the promoted images.
+func (b *BuildRun) Promote(from string) (record BuildRecord, err error) {
+	message := addTrailers(
+		"promote: app "+b.app.Name+": "+b.build.Source+": image tag "+b.imageTag+" from "+from,
+		"Build-Id", b.id,
+		"Source-Commit", b.srcCommit,
+		"Promoted-From", b.promotedFrom)
+
+	return b.Redeploy("promoting", message)
+}
+
+// schedulePromotion promotes the build's deployment after the branch's delay, unless
+// another deployment happened in the meantime. Scheduled promotions don't survive restarts.
+func (b *BuildRun) schedulePromotion() {
+	promotion := b.branch.Promote
+	app, buildName, from, buildID := b.app.Name, b.build.DockerName(Image{}), b.branch.Deploy, b.id
+	target := deployTarget(b.app, b.build, b.branch)
+
+	b.log.Print("- promotion to ", promotion.To, " scheduled in ", promotion.After)
+
+	time.AfterFunc(promotion.After, func() {
+		globalLock.Lock()
+		defer globalLock.Unlock()
+
+		last, err := lastDeployment(target)
+		if err != nil {
+			log.Print("promotion of ", app, " to ", promotion.To, " failed: ", err)
+			return
+		}
+		if last == nil || last.ID != buildID {
+			log.Print("promotion of ", app, " build ", buildID, " to ", promotion.To, " cancelled: superseded")
+			return
+		}
+
+		run, ok, err := planPromotion(app, buildName, from, promotion.To)
+		if err != nil {
+			notify("promotion of " + app + " from " + from + " to " + promotion.To + " failed: " + err.Error())
+			return
+		}
+		if !ok {
+			log.Print("promotion of ", app, " to ", promotion.To, ": already deployed")
+			return
+		}
+
+		run.Promote(from)
+	})
+}
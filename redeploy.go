@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+var redeployOnAppsChange bool
+
+func init() {
+	pflag.BoolVar(&redeployOnAppsChange, "redeploy-on-apps-change", false,
+		"re-apply the deploy updates of builds whose deploy configuration changed in the apps file (otherwise, only notify)")
+}
+
+// deployConfig is the part of the configuration affecting a build's deployment.
+type deployConfig struct {
+	Deploy        string
+	Branch        string
+	DeployUpdates []DeployUpdate
+	Preview       *Preview
+	CommitAuthor  *CommitAuthor
+	CommitMessage string
+}
+
+func deployConfigOf(app App, build Build, branchInfo *BranchInfo) string {
+	ba, _ := json.Marshal(deployConfig{
+		Deploy:        app.Deploy,
+		Branch:        branchInfo.Deploy,
+		DeployUpdates: build.DeployUpdates,
+		Preview:       branchInfo.Preview,
+		CommitAuthor:  app.CommitAuthor,
+		CommitMessage: app.CommitMessage,
+	})
+	return string(ba)
+}
+
+// branchKey identifies a branch config of a build across apps file revisions.
+func branchKey(app App, build Build, branchInfo *BranchInfo) string {
+	return app.Name + ":" + build.DockerName(Image{}) + ":" + branchInfo.Source + ":" + branchInfo.SourceTags
+}
+
+// deployChange is a branch config whose deployment configuration changed.
+type deployChange struct {
+	app    App
+	build  Build
+	branch *BranchInfo
+}
+
+func (c deployChange) String() string {
+	return c.app.Name + "/" + c.build.DockerName(Image{}) + " on " + c.branch.Deploy
+}
+
+// deployConfigChanges returns the (non-pattern) branch configs of project whose deployment
+// configuration is new or differs in previous.
+func deployConfigChanges(previous, project Project) (changes []deployChange) {
+	previousConfigs := map[string]string{}
+	for _, app := range previous.apps {
+		for _, build := range app.Builds {
+			for _, branchInfo := range build.Branches {
+				previousConfigs[branchKey(app, build, branchInfo)] = deployConfigOf(app, build, branchInfo)
+			}
+		}
+	}
+
+	for _, app := range project.apps {
+		for _, build := range app.Builds {
+			for _, branchInfo := range build.Branches {
+				if branchInfo.IsPattern() {
+					continue // one deployment per matched branch
+				}
+
+				if previousConfigs[branchKey(app, build, branchInfo)] == deployConfigOf(app, build, branchInfo) {
+					continue
+				}
+
+				changes = append(changes, deployChange{app, build, branchInfo})
+			}
+		}
+	}
+	return
+}
+
+// redeployChanged re-applies (or offers to) the deploy updates of the deployments whose
+// configuration changed between the previous and the new apps file.
+func redeployChanged(previous, project Project) {
+	for _, change := range deployConfigChanges(previous, project) {
+		b, err := planRedeploy(change.app, change.build, change.branch)
+		if err != nil {
+			log.Print("deploy config of ", change, " changed, but can't redeploy: ", err)
+			continue
+		}
+		if b == nil {
+			continue // never built
+		}
+
+		if !redeployOnAppsChange {
+			notify(fmt.Sprintf("deploy config of %s changed: redeploy image tag %s with POST %s/api/apps/%s/redeploy {\"branch\": %q, \"build\": %q}",
+				change, b.imageTag, *builderURL, change.app.Name, change.branch.Deploy, change.build.DockerName(Image{})))
+			continue
+		}
+
+		b.Redeploy("redeploying", b.redeployMessage("deploy config changed"))
+	}
+}
+
+// planRedeploy prepares the deployment of the images last deployed by the (non-pattern) branch
+// config, with the current configuration. If nothing was deployed on its target yet (ie: a new
+// deploy branch), the images of the last successful build of its source are deployed. b is nil
+// if there is no such build.
+func planRedeploy(app App, build Build, branchInfo *BranchInfo) (b *BuildRun, err error) {
+	var vars map[string]string
+	if branchInfo.SourceTags == "" {
+		vars, _ = branchInfo.Match(branchInfo.Source)
+		branchInfo = branchInfo.Expand(branchInfo.Source, vars)
+	}
+
+	record, err := lastDeployment(deployTarget(app, build, branchInfo))
+	if err == nil && record == nil {
+		record, err = lastSourceBuild(app, build, branchInfo)
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to read build history: %w", err)
+		return
+	}
+	if record == nil {
+		return
+	}
+
+	b = redeployRun(app, build, branchInfo, vars, *record)
+	b.redeployOf = record.ID
+	return
+}
+
+// lastSourceBuild returns the last successful build of the branch config's source (branch or tags).
+func lastSourceBuild(app App, build Build, branchInfo *BranchInfo) (record *BuildRecord, err error) {
+	return findLastBuildRecord(func(r BuildRecord) bool {
+		if !r.Success || !r.isBuild() || r.Skipped != "" || len(r.Images) == 0 ||
+			r.App != app.Name || r.Build != build.DockerName(Image{}) {
+			return false
+		}
+
+		if branchInfo.SourceTags != "" {
+			tag, isTag := strings.CutPrefix(r.SrcRef, "refs/tags/")
+			match, _ := path.Match(branchInfo.SourceTags, tag)
+			return isTag && match
+		}
+		return r.SrcRef == branchInfo.Source
+	})
+}
+
+func (b *BuildRun) redeployMessage(reason string) string {
+	return addTrailers(
+		"redeploy: app "+b.app.Name+": "+b.build.Source+": image tag "+b.imageTag+" ("+reason+")",
+		"Build-Id", b.id,
+		"Source-Commit", b.srcCommit,
+		"Redeploy-Of", b.redeployOf)
+}
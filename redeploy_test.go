@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+)
+
+func Example_deployConfigChanges() {
+	app := func(updates ...DeployUpdate) App {
+		return App{Name: "web", Deploy: "infra", Builds: []Build{{
+			Source: "web",
+			Branches: []*BranchInfo{
+				{Source: "main", Deploy: "staging"},
+				{Source: "release", Deploy: "production"},
+				{Source: "feature/*", Deploy: "previews"},
+			},
+			DeployUpdates: updates,
+		}}}
+	}
+
+	previous := Project{apps: []App{app()}}
+	project := Project{apps: []App{app(DeployUpdate{Script: "echo $IMAGE_TAG"})}}
+
+	withQA := Project{apps: []App{app()}}
+	withQA.apps[0].Builds[0].Branches = append(withQA.apps[0].Builds[0].Branches, &BranchInfo{Source: "main", Deploy: "qa"})
+
+	fmt.Println(deployConfigChanges(previous, previous))
+	fmt.Println(deployConfigChanges(previous, project))
+	fmt.Println(deployConfigChanges(previous, withQA))
+
+	// Output:
+	// []
+	// [web/web on staging web/web on production]
+	// [web/web on qa]
+}
+
+func Example_planRedeploy() {
+	dir, cleanup := testDir("redeploy-test")
+	defer cleanup()
+
+	defer func(prevWorkDir string) { *workDir = prevWorkDir }(*workDir)
+	*workDir = dir
+
+	app := App{Name: "web", Deploy: "infra"}
+	build := Build{Source: "web"}
+
+	for _, r := range []BuildRecord{ // oldest first
+		{ID: "1", SrcRef: "main", Deploy: "staging", Target: "web:staging:web", ImageTag: "v1"},
+		{ID: "2", SrcRef: "release", Deploy: "production", Target: "web:production:web", ImageTag: "v0"},
+	} {
+		r.App, r.Build, r.Success, r.Deployed = "web", "web", true, true
+		r.Images = []ImageRecord{{Ref: "web:" + r.ImageTag}}
+		saveBuildRecord(r)
+	}
+
+	for _, branchInfo := range []*BranchInfo{
+		{Source: "release", Deploy: "production"},
+		{Source: "main", Deploy: "qa"}, // new deploy branch
+		{Source: "hotfix", Deploy: "hotfix"},
+	} {
+		b, err := planRedeploy(app, build, branchInfo)
+		if err != nil {
+			panic(err)
+		}
+		if b == nil {
+			fmt.Println(branchInfo.Deploy, "never built")
+			continue
+		}
+		fmt.Println(branchInfo.Deploy, b.imageTag, "redeploy of", b.redeployOf)
+	}
+
+	// Output:
+	// production v0 redeploy of 2
+	// qa v1 redeploy of 1
+	// hotfix never built
+}
@@ -1,8 +1,78 @@
 package main
 
+import "strings"
+
 type RepoRef struct {
 	Repo   string
 	Branch string
 }
 
 func (ref RepoRef) URL() string { return gitURL(ref.Repo) }
+
+// repoName returns the name of a repository given as in apps.yaml: the name itself, or the
+// path of a full URL.
+func repoName(repo string) string {
+	if !isFullGitURL(repo) {
+		return repo
+	}
+	_, path, _ := strings.Cut(canonicalRepo(repo), "/")
+	return path
+}
+
+// repoDir returns the local directory name of a repository given as in apps.yaml.
+func repoDir(repo string) string {
+	if !isFullGitURL(repo) {
+		return repo
+	}
+	return canonicalRepo(repo)
+}
+
+// triggerRepo is a repository reported by a trigger.
+type triggerRepo struct {
+	URL string
+	// Name is the repository name relative to the git prefix or an allowed prefix, if any.
+	Name string
+}
+
+func newTriggerRepo(url string) (repo triggerRepo) {
+	repo.URL = url
+
+	if name, ok := cutAllowedPrefix(url); ok {
+		repo.Name = strings.TrimSuffix(name, ".git")
+	}
+	return
+}
+
+func (t triggerRepo) String() string {
+	if t.Name != "" {
+		return t.Name
+	}
+	return t.URL
+}
+
+// Is returns true if the trigger's repository is repo, given as in apps.yaml (name or full URL).
+func (t triggerRepo) Is(repo string) bool {
+	if repo == "" {
+		return false
+	}
+	if t.Name != "" && t.Name == repo {
+		return true
+	}
+	return canonicalRepo(gitURL(repo)) == canonicalRepo(t.URL)
+}
+
+// known returns true if the repository is referenced by the project.
+func (t triggerRepo) known(project Project) bool {
+	if t.Is(appsRepo.Repo) {
+		return true
+	}
+
+	for _, app := range project.apps {
+		for _, build := range app.Builds {
+			if t.Is(build.Source) || t.Is(build.Overlay) {
+				return true
+			}
+		}
+	}
+	return false
+}
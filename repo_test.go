@@ -0,0 +1,35 @@
+package main
+
+import "fmt"
+
+func Example_triggerRepo() {
+	gitPrefix = "git@git.example.org:"
+	defer func() { gitPrefix = "" }()
+
+	for _, url := range []string{
+		"git@git.example.org:team/app.git",
+		"https://git.example.org/team/app.git",
+		"ssh://git@git.example.org:2222/team/app",
+		"https://git.example.org/team/other.git",
+	} {
+		repo := newTriggerRepo(url)
+		fmt.Println(url, repo.Is("team/app"), repo.Is("https://git.example.org/team/app"))
+	}
+
+	// Output:
+	// git@git.example.org:team/app.git true true
+	// https://git.example.org/team/app.git true true
+	// ssh://git@git.example.org:2222/team/app true true
+	// https://git.example.org/team/other.git false false
+}
+
+func Example_repoName() {
+	fmt.Println(repoName("team/app"))
+	fmt.Println(repoName("https://git.example.org/team/app.git"))
+	fmt.Println(repoName("git@git.example.org:team/app.git"))
+
+	// Output:
+	// team/app
+	// team/app
+	// team/app
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// planRollback prepares the re-deployment of a previous successful build of the app's
+// deploy branch, or of the build toID if given. buildName (the
+// build's docker name) is needed when several builds of the app deploy on the branch.
+func planRollback(appName, branch, buildName, toID string) (b *BuildRun, err error) {
+	app, err := findApp(appName)
+	if err != nil {
+		return
+	}
+
+	records, err := findBuildRecords(func(r BuildRecord) bool {
+		return r.App == appName && r.Deployed &&
+			r.Deploy == branch &&
+			(buildName == "" || r.Build == buildName)
+	})
+	if err != nil {
+		err = fmt.Errorf("failed to read build history: %w", err)
+		return
+	}
+	if len(records) == 0 {
+		err = fmt.Errorf("no deployed build of app %s on branch %s", appName, branch)
+		return
+	}
+
+	current := records[0]
+	for _, r := range records {
+		if r.Target != current.Target {
+			err = fmt.Errorf("several deployments of app %s match branch %s (%s and %s), please specify the build", appName, branch, current.Target, r.Target)
+			return
+		}
+	}
+
+	var to *BuildRecord
+	if toID != "" {
+		for i := range records {
+			if records[i].ID == toID {
+				to = &records[i]
+				break
+			}
+		}
+		if to == nil {
+			err = fmt.Errorf("build %s is not a deployed build of app %s on branch %s", toID, appName, branch)
+			return
+		}
+	} else {
+		to = previousDeployment(records)
+		if to == nil {
+			err = fmt.Errorf("no previous deployment of app %s on branch %s to roll back to", appName, branch)
+			return
+		}
+	}
+
+	b, err = rollbackRun(*app, *to)
+	if err != nil {
+		return
+	}
+
+	b.rollbackFrom = current.ImageTag
+	return
+}
+
+func findApp(name string) (app *App, err error) {
+	for i := range currentProject.apps {
+		if currentProject.apps[i].Name == name {
+			return &currentProject.apps[i], nil
+		}
+	}
+	err = fmt.Errorf("unknown app %q", name)
+	return
+}
+
+// previousDeployment returns the most recent deployment of other images than the current
+// ones (records[0]) that were not rolled back from.
+func previousDeployment(records []BuildRecord) *BuildRecord {
+	skip := map[string]bool{records[0].ImageTag: true}
+
+	for i, r := range records {
+		if r.RollbackFrom != "" {
+			skip[r.RollbackFrom] = true
+		}
+		if !skip[r.ImageTag] {
+			return &records[i]
+		}
+	}
+	return nil
+}
+
+// rollbackRun returns the run re-deploying the record's images with the current config.
+func rollbackRun(app App, record BuildRecord) (b *BuildRun, err error) {
+	for _, build := range app.Builds {
+		if build.DockerName(Image{}) != record.Build {
+			continue
+		}
+
+		branchInfo, vars, ok := deployingBranch(app, build, record)
+		if !ok {
+			continue
+		}
+
+		b = redeployRun(app, build, branchInfo, vars, record)
+		b.rollbackTo = record.ID
+		return
+	}
+
+	err = fmt.Errorf("build %s (%s) is not deployed by the current config of app %s", record.ID, record.Target, app.Name)
+	return
+}
+
+// deployingBranch returns the branch config of build deploying to the record's target,
+// expanded like a build of the record's source ref would. Only pattern configs are matched
+// against the source ref: promoted records keep the source ref of the branch promoted from.
+func deployingBranch(app App, build Build, record BuildRecord) (branchInfo *BranchInfo, vars map[string]string, ok bool) {
+	for _, bi := range build.Branches {
+		vars = nil
+
+		switch {
+		case bi.SourceTags != "":
+			// tag builds are not expanded
+
+		case bi.IsPattern():
+			var match bool
+			if vars, match = bi.Match(record.SrcRef); !match {
+				continue
+			}
+			bi = bi.Expand(record.SrcRef, vars)
+
+		default:
+			vars, _ = bi.Match(bi.Source)
+			bi = bi.Expand(bi.Source, vars)
+		}
+
+		if deployTarget(app, build, bi) == record.Target {
+			return bi, vars, true
+		}
+	}
+	return nil, nil, false
+}
+
+// redeployRun returns a run deploying the record's images on branchInfo, without rebuilding them.
+func redeployRun(app App, build Build, branchInfo *BranchInfo, vars map[string]string, record BuildRecord) (b *BuildRun) {
+	b = &BuildRun{
+		app:    app,
+		build:  build,
+		branch: branchInfo,
+		srcRef: record.SrcRef,
+		vars:   vars,
+
+		id:            newUlid(),
+		srcCommit:     record.SrcCommit,
+		overlayCommit: record.OverlayCommit,
+		submodules:    record.Submodules,
+		imageTag:      record.ImageTag,
+	}
+
+	for _, img := range record.Images {
+		b.images = append(b.images, builtImage{
+			Image:  Image{Name: img.Name},
+			Ref:    img.Ref,
+			Digest: img.Digest,
+		})
+	}
+	return
+}
+
+// Rollback re-applies the deploy updates with the images of the build rolled back to.
+func (b *BuildRun) Rollback() (record BuildRecord, err error) {
+	message := addTrailers(
+		"rollback: app "+b.app.Name+": "+b.build.Source+": image tag "+b.imageTag+" (was "+b.rollbackFrom+")",
+		"Build-Id", b.id,
+		"Source-Commit", b.srcCommit,
+		"Rollback-To", b.rollbackTo)
+
+	return b.Redeploy("rolling back", message)
+}
+
+// Redeploy applies the deploy updates with the run's images, which are not rebuilt.
+func (b *BuildRun) Redeploy(action, message string) (record BuildRecord, err error) {
+	notifPrefix := fmt.Sprint("[", b.id, "]("+*builderURL+"/build-logs/"+b.id+") ", action, " ", b.app.Name, "/", b.build.Source,
+		" (", b.srcRefDesc(), ") to ", b.branch.Deploy, " with image tag ", b.imageTag)
+
+	defer func() {
+		if err != nil {
+			notify(notifPrefix + " failed: " + err.Error())
+		} else {
+			notify(notifPrefix + " successful")
+		}
+	}()
+
+	logOut, log, err := createBuildLog(b.id)
+	if err != nil {
+		log.Print("failed to create log file: ", err)
+		return
+	}
+	defer logOut.Close()
+	b.log = log
+
+	unlock, err := lockWorkDir()
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	log.Print("- ", action, " ", b.app.Name, "/", b.build.Source, ": deploying image tag ", b.imageTag, " to ", b.branch.Deploy)
+
+	err = b.deploy(gitOps{log}, message)
+
+	record = b.record(err)
+	if recordErr := saveBuildRecord(record); recordErr != nil {
+		log.Print("WARNING: failed to save build record: ", recordErr)
+	}
+
+	if err != nil {
+		return
+	}
+
+	refs := make([]string, 0, len(b.images))
+	for _, img := range b.images {
+		refs = append(refs, img.Ref)
+	}
+
+	if recordErr := recordDeployed(deployTarget(b.app, b.build, b.branch), refs); recordErr != nil {
+		log.Print("WARNING: failed to record deployed images: ", recordErr)
+	}
+	return
+}
+
+// rollbackCmd is the "rollback <app> <branch> [build] [to build ID]" subcommand.
+func rollbackCmd(args []string) {
+	if len(args) < 2 || len(args) > 4 {
+		log.Fatal("usage: rollback <app> <branch> [build] [to build ID]")
+	}
+
+	buildName, toID := "", ""
+	if len(args) > 2 {
+		buildName = args[2]
+	}
+	if len(args) > 3 {
+		toID = args[3]
+	}
+
+	globalLock.Lock()
+	defer globalLock.Unlock()
+
+	// the server may be deploying too: hold the work dir lock from planning to deployment
+	unlock, err := lockWorkDir()
+	if err != nil {
+		log.Fatal("rollback: ", err)
+	}
+	defer unlock()
+
+	b, err := planRollback(args[0], args[1], buildName, toID)
+	if err != nil {
+		log.Fatal("rollback: ", err)
+	}
+
+	record, err := b.Rollback()
+	if err != nil {
+		log.Fatal("rollback failed: ", err)
+	}
+
+	log.Print("rolled back to image tag ", record.ImageTag, " (build ", record.ID, ")")
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+)
+
+func Example_previousDeployment() {
+	records := []BuildRecord{ // most recent first
+		{ID: "5", ImageTag: "v1", RollbackTo: "1", RollbackFrom: "v3"},
+		{ID: "4", ImageTag: "v3"},
+		{ID: "3", ImageTag: "v1", RollbackTo: "1", RollbackFrom: "v2"},
+		{ID: "2", ImageTag: "v2"},
+		{ID: "1", ImageTag: "v1"},
+		{ID: "0", ImageTag: "v0"},
+	}
+
+	fmt.Println(previousDeployment(records).ID)
+	fmt.Println(previousDeployment(records[1:]).ID)
+
+	// Output:
+	// 0
+	// 3
+}
+
+func Example_rollbackAfterPromotion() {
+	dir, cleanup := testDir("rollback-test")
+	defer cleanup()
+
+	defer func(prevWorkDir string, prevProject Project) {
+		*workDir, currentProject = prevWorkDir, prevProject
+	}(*workDir, currentProject)
+	*workDir = dir
+
+	app := App{Name: "web", Builds: []Build{{
+		Source: "web",
+		Branches: []*BranchInfo{
+			{Source: "main", Deploy: "staging", Promote: &Promotion{To: "production"}},
+			{Source: "release", Deploy: "production"},
+		},
+	}}}
+	currentProject = Project{apps: []App{app}}
+
+	staging, production := "web:staging:web", "web:production:web"
+
+	for _, r := range []BuildRecord{ // oldest first
+		{ID: "1", SrcRef: "main", Deploy: "staging", Target: staging, ImageTag: "v1"},
+		{ID: "2", SrcRef: "main", Deploy: "production", Target: production, ImageTag: "v1", PromotedFrom: "1"},
+		{ID: "3", SrcRef: "main", Deploy: "staging", Target: staging, ImageTag: "v2"},
+		{ID: "4", SrcRef: "main", Deploy: "production", Target: production, ImageTag: "v2", PromotedFrom: "3"},
+	} {
+		r.App, r.Build, r.Success, r.Deployed = "web", "web", true, true
+		if err := saveBuildRecord(r); err != nil {
+			panic(err)
+		}
+	}
+
+	for _, branch := range []string{"production", "staging"} {
+		b, err := planRollback("web", branch, "", "")
+		if err != nil {
+			fmt.Println(branch, "error:", err)
+			continue
+		}
+		fmt.Println(branch, "->", b.branch.Deploy, b.imageTag, "rolling back to", b.rollbackTo, "from", b.rollbackFrom)
+	}
+
+	// Output:
+	// production -> production v1 rolling back to 2 from v2
+	// staging -> staging v1 rolling back to 1 from v2
+}
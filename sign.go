@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/distribution/reference"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/spf13/pflag"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/errdef"
+	"oras.land/oras-go/v2/registry/remote"
+	"oras.land/oras-go/v2/registry/remote/auth"
+	"oras.land/oras-go/v2/registry/remote/credentials"
+)
+
+var (
+	signKeyPath       string
+	signAttest        bool
+	registryPlainHTTP bool
+)
+
+func init() {
+	pflag.StringVar(&signKeyPath, "sign-key", "", "PEM private key (unencrypted ECDSA, RSA or Ed25519) used to sign pushed images")
+	pflag.BoolVar(&signAttest, "attest", true, "attach a provenance attestation to signed images")
+	pflag.BoolVar(&registryPlainHTTP, "registry-plain-http", false, "use plain HTTP to reach the registry when signing")
+}
+
+const (
+	cosignSignatureAnnotation = "dev.cosignproject.cosign/signature"
+	cosignSimpleSigningType   = "application/vnd.dev.cosign.simplesigning.v1+json"
+	dsseEnvelopeType          = "application/vnd.dsse.envelope.v1+json"
+	inTotoPayloadType         = "application/vnd.in-toto+json"
+	slsaProvenanceType        = "https://slsa.dev/provenance/v0.2"
+)
+
+func loadSignKey(path string) (key crypto.Signer, err error) {
+	ba, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	block, _ := pem.Decode(ba)
+	if block == nil {
+		err = fmt.Errorf("no PEM data in %s", path)
+		return
+	}
+
+	var parsed any
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		err = fmt.Errorf("unsupported PEM block %q in %s (encrypted keys are not supported)", block.Type, path)
+	}
+	if err != nil {
+		return
+	}
+
+	key, ok := parsed.(crypto.Signer)
+	if !ok {
+		err = fmt.Errorf("key in %s can't sign", path)
+	}
+	return
+}
+
+func signPayload(key crypto.Signer, payload []byte) ([]byte, error) {
+	if _, ok := key.(ed25519.PrivateKey); ok {
+		return key.Sign(rand.Reader, payload, crypto.Hash(0))
+	}
+
+	h := sha256.Sum256(payload)
+	return key.Sign(rand.Reader, h[:], crypto.SHA256)
+}
+
+// signImage stores a cosign compatible signature of the image in the registry and,
+// if enabled, a signed provenance attestation.
+func (b *BuildRun) signImage(ctx context.Context, img builtImage) (err error) {
+	log := b.log
+
+	key, err := loadSignKey(signKeyPath)
+	if err != nil {
+		err = fmt.Errorf("failed to load signing key: %w", err)
+		return
+	}
+
+	repo, name, err := registryRepository(img.Ref)
+	if err != nil {
+		return
+	}
+
+	algo, hex, ok := strings.Cut(img.Digest, ":")
+	if !ok {
+		err = fmt.Errorf("invalid image digest: %q", img.Digest)
+		return
+	}
+
+	// signature
+	payload, err := json.Marshal(map[string]any{
+		"critical": map[string]any{
+			"identity": map[string]string{"docker-reference": name},
+			"image":    map[string]string{"docker-manifest-digest": img.Digest},
+			"type":     "cosign container image signature",
+		},
+		"optional": map[string]string{
+			"build-id":      b.id,
+			"source-commit": b.srcCommit,
+		},
+	})
+	if err != nil {
+		return
+	}
+
+	sig, err := signPayload(key, payload)
+	if err != nil {
+		err = fmt.Errorf("failed to sign: %w", err)
+		return
+	}
+
+	sigTag := algo + "-" + hex + ".sig"
+	log.Print("- signing ", img.Ref, " (", img.Digest, ") as ", sigTag)
+
+	err = pushCosignLayer(ctx, repo, sigTag, cosignSimpleSigningType, payload, map[string]string{
+		cosignSignatureAnnotation: base64.StdEncoding.EncodeToString(sig),
+	})
+	if err != nil {
+		err = fmt.Errorf("failed to push signature: %w", err)
+		return
+	}
+
+	if !signAttest {
+		return
+	}
+
+	// provenance attestation
+	statement, err := json.Marshal(map[string]any{
+		"_type":         "https://in-toto.io/Statement/v0.1",
+		"predicateType": slsaProvenanceType,
+		"subject": []any{
+			map[string]any{"name": name, "digest": map[string]string{algo: hex}},
+		},
+		"predicate": b.provenance(),
+	})
+	if err != nil {
+		return
+	}
+
+	pae := fmt.Sprintf("DSSEv1 %d %s %d ", len(inTotoPayloadType), inTotoPayloadType, len(statement))
+	sig, err = signPayload(key, append([]byte(pae), statement...))
+	if err != nil {
+		err = fmt.Errorf("failed to sign attestation: %w", err)
+		return
+	}
+
+	envelope, err := json.Marshal(map[string]any{
+		"payloadType": inTotoPayloadType,
+		"payload":     base64.StdEncoding.EncodeToString(statement),
+		"signatures":  []any{map[string]string{"keyid": "", "sig": base64.StdEncoding.EncodeToString(sig)}},
+	})
+	if err != nil {
+		return
+	}
+
+	attTag := algo + "-" + hex + ".att"
+	log.Print("- attesting ", img.Ref, " as ", attTag)
+
+	err = pushCosignLayer(ctx, repo, attTag, dsseEnvelopeType, envelope, map[string]string{
+		cosignSignatureAnnotation: "",
+		"predicateType":           slsaProvenanceType,
+	})
+	if err != nil {
+		err = fmt.Errorf("failed to push attestation: %w", err)
+		return
+	}
+
+	return
+}
+
+func (b *BuildRun) provenance() map[string]any {
+	materials := []any{
+		map[string]any{
+			"uri":    gitURL(b.build.Source) + "@" + fullRefName(b.srcRef),
+			"digest": map[string]string{"sha1": b.srcCommit},
+		},
+	}
+	if b.overlayCommit != "" {
+		materials = append(materials, map[string]any{
+			"uri":    gitURL(b.build.Overlay) + "@refs/heads/" + b.branch.Overlay,
+			"digest": map[string]string{"sha1": b.overlayCommit},
+		})
+	}
+
+	for subPath, commit := range b.submodules {
+		materials = append(materials, map[string]any{
+			"uri":    gitURL(b.build.Source) + "#" + subPath,
+			"digest": map[string]string{"sha1": commit},
+		})
+	}
+
+	return map[string]any{
+		"builder":   map[string]string{"id": *builderURL},
+		"buildType": "https://github.com/mcluseau/gitops-builder@v1",
+		"invocation": map[string]any{
+			"configSource": map[string]any{
+				"uri":        appsRepo.URL() + "@refs/heads/" + appsRepo.Branch,
+				"digest":     map[string]string{"sha1": appsCommit},
+				"entryPoint": b.app.Name,
+			},
+			"parameters": map[string]any{
+				"app":    b.app.Name,
+				"source": b.build.Source,
+				"ref":    b.srcRef,
+			},
+		},
+		"metadata": map[string]any{
+			"buildInvocationId": b.id,
+			"buildFinishedOn":   time.Now().UTC().Format(time.RFC3339),
+		},
+		"materials": materials,
+	}
+}
+
+// registryRepository returns the registry repository of an image reference, and its normalized name.
+func registryRepository(imageRef string) (repo *remote.Repository, name string, err error) {
+	named, err := reference.ParseNormalizedNamed(imageRef)
+	if err != nil {
+		return
+	}
+
+	name = named.Name()
+
+	host := reference.Domain(named)
+	if host == "docker.io" {
+		host = "registry-1.docker.io"
+	}
+
+	repo, err = remote.NewRepository(host + "/" + reference.Path(named))
+	if err != nil {
+		return
+	}
+
+	store, err := credentials.NewStoreFromDocker(credentials.StoreOptions{})
+	if err != nil {
+		err = fmt.Errorf("failed to load docker credentials: %w", err)
+		return
+	}
+
+	repo.PlainHTTP = registryPlainHTTP
+	repo.Client = &auth.Client{
+		Cache:      auth.NewCache(),
+		Credential: credentials.Credential(store),
+	}
+	return
+}
+
+// pushCosignLayer adds a layer to the manifest tagged tag, creating it if needed.
+func pushCosignLayer(ctx context.Context, repo *remote.Repository, tag, mediaType string, payload []byte, annotations map[string]string) (err error) {
+	layer := content.NewDescriptorFromBytes(mediaType, payload)
+	layer.Annotations = annotations
+
+	manifest := ocispec.Manifest{MediaType: ocispec.MediaTypeImageManifest}
+	manifest.SchemaVersion = 2
+
+	_, existing, err := oras.FetchBytes(ctx, repo, tag, oras.DefaultFetchBytesOptions)
+	switch {
+	case errors.Is(err, errdef.ErrNotFound):
+		err = nil
+	case err != nil:
+		return
+	default:
+		if err = json.Unmarshal(existing, &manifest); err != nil {
+			return fmt.Errorf("failed to parse existing manifest %s: %w", tag, err)
+		}
+	}
+
+	for _, l := range manifest.Layers {
+		if l.Digest == layer.Digest && l.Annotations[cosignSignatureAnnotation] == annotations[cosignSignatureAnnotation] {
+			return // already there
+		}
+	}
+
+	if _, err = oras.PushBytes(ctx, repo, mediaType, payload); err != nil && !errors.Is(err, errdef.ErrAlreadyExists) {
+		return
+	}
+
+	manifest.Layers = append(manifest.Layers, layer)
+
+	diffIDs := make([]string, 0, len(manifest.Layers))
+	for _, l := range manifest.Layers {
+		diffIDs = append(diffIDs, strconv.Quote(l.Digest.String()))
+	}
+
+	config := []byte(`{"architecture":"","os":"","config":{},"rootfs":{"type":"layers","diff_ids":[` + strings.Join(diffIDs, ",") + `]}}`)
+
+	manifest.Config, err = oras.PushBytes(ctx, repo, ocispec.MediaTypeImageConfig, config)
+	if err != nil && !errors.Is(err, errdef.ErrAlreadyExists) {
+		return
+	}
+
+	manifestBytes, err := json.Marshal(manifest)
+	if err != nil {
+		return
+	}
+
+	_, err = oras.TagBytes(ctx, repo, ocispec.MediaTypeImageManifest, manifestBytes, tag)
+	return
+}
+
+// imageDigest returns the registry digest of a pushed image.
+func imageDigest(inspectRepoDigests []string, imageRef string) (digest string, ok bool) {
+	name, _ := splitImageTag(imageRef)
+
+	for _, repoDigest := range inspectRepoDigests {
+		n, d, found := strings.Cut(repoDigest, "@")
+		if found && n == name {
+			return d, true
+		}
+	}
+	return
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+func Example_signPayload() {
+	dir, cleanup := testDir("sign-test")
+	defer cleanup()
+
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecDER, _ := x509.MarshalECPrivateKey(ecKey)
+
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	edDER, _ := x509.MarshalPKCS8PrivateKey(edKey)
+
+	payload := []byte(`{"critical":{}}`)
+
+	for _, k := range []struct {
+		blockType string
+		der       []byte
+		verify    func(sig []byte) bool
+	}{
+		{"EC PRIVATE KEY", ecDER, func(sig []byte) bool {
+			h := sha256.Sum256(payload)
+			return ecdsa.VerifyASN1(&ecKey.PublicKey, h[:], sig)
+		}},
+		{"PRIVATE KEY", edDER, func(sig []byte) bool {
+			return ed25519.Verify(edKey.Public().(ed25519.PublicKey), payload, sig)
+		}},
+		{"ENCRYPTED PRIVATE KEY", edDER, nil},
+	} {
+		path := filepath.Join(dir, "key.pem")
+		os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: k.blockType, Bytes: k.der}), 0600)
+
+		key, err := loadSignKey(path)
+		if err != nil {
+			fmt.Println(k.blockType, "error:", err != nil)
+			continue
+		}
+
+		sig, err := signPayload(key, payload)
+		fmt.Println(k.blockType, "verified:", err == nil && k.verify(sig))
+	}
+
+	// Output:
+	// EC PRIVATE KEY verified: true
+	// PRIVATE KEY verified: true
+	// ENCRYPTED PRIVATE KEY error: true
+}
+
+func Example_imageDigest() {
+	repoDigests := []string{
+		"registry.example.org/other@sha256:1111",
+		"registry.example.org/app@sha256:2222",
+	}
+
+	for _, ref := range []string{"registry.example.org/app:v1", "registry.example.org:5000/app:v1"} {
+		digest, ok := imageDigest(repoDigests, ref)
+		fmt.Printf("%q %v\n", digest, ok)
+	}
+
+	// Output:
+	// "sha256:2222" true
+	// "" false
+}
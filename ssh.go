@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/spf13/pflag"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+var (
+	sshKnownHostsFiles []string
+	sshHostKeys        []string
+	sshKeyFile         string
+
+	// sshKnownHosts are the known_hosts files trusted host keys are read from, if explicitly configured
+	sshKnownHosts []string
+	// sshHostKeyCallback verifies host keys against sshKnownHosts
+	sshHostKeyCallback ssh.HostKeyCallback
+)
+
+func init() {
+	pflag.StringArrayVar(&sshKnownHostsFiles, "ssh-known-hosts", nil, "known_hosts file of trusted SSH host keys (can be repeated)")
+	pflag.StringArrayVar(&sshHostKeys, "ssh-host-key", nil, "trusted SSH host key, as a known_hosts line (ie: \"git.myorg ssh-ed25519 AAAA...\", can be repeated)")
+	pflag.StringVar(&sshKeyFile, "ssh-key-file", "", "SSH deploy key used instead of the SSH agent (user from $GIT_SSH_USER, password from $GIT_SSH_KEY_PASSWORD, not usable to push)")
+}
+
+// setupSSH loads the SSH deploy key and trusted host keys, and applies the host keys
+// to every SSH authentication. Without explicit host keys, go-git's default known_hosts
+// handling applies.
+func setupSSH() (err error) {
+	if sshKeyFile != "" {
+		user := firstNonEmpty(os.Getenv("GIT_SSH_USER"), "git")
+
+		log.Print("setting git auth from --ssh-key-file")
+		gitAuth, err = gitssh.NewPublicKeysFromFile(user, sshKeyFile, os.Getenv("GIT_SSH_KEY_PASSWORD"))
+		if err != nil {
+			return fmt.Errorf("failed to load SSH key: %w", err)
+		}
+	}
+
+	if len(sshKnownHostsFiles) == 0 && len(sshHostKeys) == 0 {
+		return
+	}
+
+	files := append([]string{}, sshKnownHostsFiles...)
+
+	if len(sshHostKeys) != 0 {
+		// knownhosts (and ssh) only read files, so write the inline keys to one
+		inlineFile := filepath.Join(*workDir, "ssh_host_keys")
+		if err = os.MkdirAll(*workDir, 0750); err != nil {
+			return
+		}
+		if err = os.WriteFile(inlineFile, []byte(strings.Join(sshHostKeys, "\n")+"\n"), 0600); err != nil {
+			return fmt.Errorf("failed to write SSH host keys: %w", err)
+		}
+		files = append(files, inlineFile)
+	}
+
+	for idx, file := range files {
+		if files[idx], err = filepath.Abs(file); err != nil {
+			return
+		}
+	}
+
+	callback, err := knownhosts.New(files...)
+	if err != nil {
+		return fmt.Errorf("failed to load trusted SSH host keys: %w", err)
+	}
+
+	sshKnownHosts = files
+	sshHostKeyCallback = explainHostKeyErrors(callback)
+
+	setHostKeyCallback(gitAuth)
+	for _, cred := range gitCredentials {
+		setHostKeyCallback(cred.auth)
+	}
+
+	log.Printf("trusting SSH host keys from %s", strings.Join(files, ", "))
+	return
+}
+
+func setHostKeyCallback(auth transport.AuthMethod) {
+	if sshHostKeyCallback == nil {
+		return
+	}
+
+	switch auth := auth.(type) {
+	case *gitssh.PublicKeys:
+		auth.HostKeyCallback = sshHostKeyCallback
+	case *gitssh.PublicKeysCallback:
+		auth.HostKeyCallback = sshHostKeyCallback
+	case *gitssh.Password:
+		auth.HostKeyCallback = sshHostKeyCallback
+	}
+}
+
+// explainHostKeyErrors turns knownhosts errors into actionable ones.
+func explainHostKeyErrors(callback ssh.HostKeyCallback) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) (err error) {
+		err = callback(hostname, remote, key)
+		if err == nil {
+			return
+		}
+
+		got := key.Type() + " " + ssh.FingerprintSHA256(key)
+
+		var revokedErr *knownhosts.RevokedError
+		if errors.As(err, &revokedErr) {
+			return fmt.Errorf("SSH host key of %s is revoked (%s)", hostname, got)
+		}
+
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) {
+			return
+		}
+
+		if len(keyErr.Want) == 0 {
+			return fmt.Errorf("SSH host key of %s is not trusted (%s): add it with --ssh-host-key or --ssh-known-hosts", hostname, got)
+		}
+
+		want := make([]string, 0, len(keyErr.Want))
+		for _, k := range keyErr.Want {
+			want = append(want, fmt.Sprintf("%s %s (%s:%d)", k.Key.Type(), ssh.FingerprintSHA256(k.Key), k.Filename, k.Line))
+		}
+		return fmt.Errorf("SSH host key mismatch for %s: got %s, trusted: %s", hostname, got, strings.Join(want, ", "))
+	}
+}
+
+// sshCommand returns the ssh command the git CLI should use, or "" for the default.
+func sshCommand(keyFile string) string {
+	args := []string{"ssh"}
+
+	if keyFile != "" {
+		args = append(args, "-i", shellQuote(keyFile), "-o", "IdentitiesOnly=yes")
+	}
+
+	if len(sshKnownHosts) != 0 {
+		args = append(args,
+			"-o", shellQuote("UserKnownHostsFile="+strings.Join(sshKnownHosts, " ")),
+			"-o", "StrictHostKeyChecking=yes")
+	}
+
+	if len(args) == 1 {
+		return ""
+	}
+	return strings.Join(args, " ")
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
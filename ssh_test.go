@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func Example_explainHostKeyErrors() {
+	dir, cleanup := testDir("ssh-test")
+	defer cleanup()
+
+	keys := map[string]ssh.PublicKey{}
+	names := []string{}
+	for i, name := range []string{"trusted", "other", "revoked"} {
+		seed := make([]byte, ed25519.SeedSize)
+		seed[0] = byte(i + 1)
+		key, err := ssh.NewPublicKey(ed25519.NewKeyFromSeed(seed).Public())
+		if err != nil {
+			panic(err)
+		}
+		keys[name] = key
+		names = append(names, ssh.FingerprintSHA256(key), "<"+name+">")
+	}
+
+	knownHosts := filepath.Join(dir, "known_hosts")
+	err := os.WriteFile(knownHosts, []byte(
+		knownhosts.Line([]string{"git.example.org"}, keys["trusted"])+"\n"+
+			"@revoked "+knownhosts.Line([]string{"*"}, keys["revoked"])+"\n"), 0600)
+	if err != nil {
+		panic(err)
+	}
+	names = append(names, dir+"/", "")
+
+	callback, err := knownhosts.New(knownHosts)
+	if err != nil {
+		panic(err)
+	}
+	callback = explainHostKeyErrors(callback)
+
+	replacer := strings.NewReplacer(names...)
+	remote := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 22}
+
+	for _, c := range []struct{ host, key string }{
+		{"git.example.org", "trusted"},
+		{"git.example.org", "other"},
+		{"git.other.org", "other"},
+		{"git.other.org", "revoked"},
+	} {
+		err := callback(c.host+":22", remote, keys[c.key])
+		fmt.Println(replacer.Replace(fmt.Sprint(err)))
+	}
+
+	// Output:
+	// <nil>
+	// SSH host key mismatch for git.example.org:22: got ssh-ed25519 <other>, trusted: ssh-ed25519 <trusted> (known_hosts:1)
+	// SSH host key of git.other.org:22 is not trusted (ssh-ed25519 <other>): add it with --ssh-host-key or --ssh-known-hosts
+	// SSH host key of git.other.org:22 is revoked (ssh-ed25519 <revoked>)
+}
+
+func Example_sshCommand() {
+	defer func(prev []string) { sshKnownHosts = prev }(sshKnownHosts)
+
+	sshKnownHosts = nil
+	fmt.Printf("%q\n", sshCommand(""))
+	fmt.Println(sshCommand("/keys/deploy key"))
+
+	sshKnownHosts = []string{"/etc/ssh/known_hosts", "/work/it's hosts"}
+	fmt.Println(sshCommand(""))
+
+	// Output:
+	// ""
+	// ssh -i '/keys/deploy key' -o IdentitiesOnly=yes
+	// ssh -o 'UserKnownHostsFile=/etc/ssh/known_hosts /work/it'\''s hosts' -o StrictHostKeyChecking=yes
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"gopkg.in/yaml.v3"
+)
+
+// Submodules is the submodules mode of a build: "" (disabled), "true" or "recursive".
+type Submodules string
+
+const (
+	SubmodulesEnabled   Submodules = "true"
+	SubmodulesRecursive Submodules = "recursive"
+)
+
+func (s *Submodules) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Value {
+	case "", "false", "no":
+		*s = ""
+	case "true", "yes":
+		*s = SubmodulesEnabled
+	case "recursive":
+		*s = SubmodulesRecursive
+	default:
+		return fmt.Errorf("line %d: invalid submodules value %q (expected true, false or recursive)", node.Line, node.Value)
+	}
+	return nil
+}
+
+// UpdateSubmodules initializes and checks out the submodules of the worktree in dir,
+// and returns their commits by path.
+func (g gitOps) UpdateSubmodules(dir string, mode Submodules) (commits map[string]string, err error) {
+	repo, err := g.Open(dir+".git", dir)
+	if err != nil {
+		return
+	}
+
+	wt, err := repo.Worktree()
+	if err != nil {
+		err = fmt.Errorf("failed to get worktree: %w", err)
+		return
+	}
+
+	subs, err := wt.Submodules()
+	if err != nil {
+		err = fmt.Errorf("failed to list submodules: %w", err)
+		return
+	}
+
+	if len(subs) == 0 {
+		return
+	}
+
+	originURL := ""
+	if remote, remoteErr := repo.Remote("origin"); remoteErr == nil {
+		originURL = remote.Config().URLs[0]
+	}
+
+	g.log.Print("- updating ", len(subs), " submodules")
+
+	commits = map[string]string{}
+	err = g.updateSubmodules(subs, originURL, "", mode == SubmodulesRecursive, commits)
+	return
+}
+
+// updateSubmodules updates each submodule with the credentials of its own URL, since
+// submodules are often hosted elsewhere than their parent, and records their commits.
+func (g gitOps) updateSubmodules(subs git.Submodules, parentURL, prefix string, recursive bool, commits map[string]string) (err error) {
+	for _, sub := range subs {
+		subURL := submoduleURL(parentURL, sub.Config().URL)
+
+		err = sub.Update(&git.SubmoduleUpdateOptions{
+			Init:              true,
+			Auth:              gitAuthFor(subURL),
+			RecurseSubmodules: git.NoRecurseSubmodules,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update submodule %s: %w", path.Join(prefix, sub.Config().Path), err)
+		}
+
+		status, statusErr := sub.Status()
+		if statusErr != nil {
+			return fmt.Errorf("failed to get submodule %s status: %w", sub.Config().Path, statusErr)
+		}
+
+		subPath := path.Join(prefix, status.Path)
+		commits[subPath] = status.Current.String()
+
+		if !recursive {
+			continue
+		}
+
+		subRepo, repoErr := sub.Repository()
+		if repoErr != nil {
+			return repoErr
+		}
+		wt, wtErr := subRepo.Worktree()
+		if wtErr != nil {
+			return wtErr
+		}
+		subSubs, subsErr := wt.Submodules()
+		if subsErr != nil {
+			return subsErr
+		}
+
+		if err = g.updateSubmodules(subSubs, subURL, subPath, recursive, commits); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// submoduleURL resolves a submodule's URL relative to its parent's remote URL, like git does
+// for URLs starting with ./ or ../
+func submoduleURL(parentURL, url string) string {
+	if !strings.HasPrefix(url, "./") && !strings.HasPrefix(url, "../") {
+		return url
+	}
+
+	base, repoPath := "", parentURL
+	if i := strings.Index(parentURL, "://"); i >= 0 {
+		if j := strings.Index(parentURL[i+3:], "/"); j >= 0 {
+			base, repoPath = parentURL[:i+3+j], parentURL[i+3+j:]
+		}
+	} else if i := strings.Index(parentURL, ":"); i >= 0 {
+		// scp-like: git@host:org/repo
+		base, repoPath = parentURL[:i+1], parentURL[i+1:]
+	}
+
+	return base + path.Join(repoPath, url)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+func ExampleSubmodules_UnmarshalYAML() {
+	for _, value := range []string{"true", "no", "recursive", "always"} {
+		var build struct{ Submodules Submodules }
+		err := yaml.Unmarshal([]byte("submodules: "+value), &build)
+		fmt.Printf("%q %v\n", build.Submodules, err)
+	}
+
+	// Output:
+	// "true" <nil>
+	// "" <nil>
+	// "recursive" <nil>
+	// "" line 1: invalid submodules value "always" (expected true, false or recursive)
+}
+
+func Example_gitOps_UpdateSubmodules() {
+	dir, cleanup := testDir("submodules-test")
+	defer cleanup()
+
+	git := testGit(dir)
+
+	git("init", "-q", "-b", "main", "lib")
+	os.WriteFile(filepath.Join(dir, "lib", "lib.go"), []byte("package lib\n"), 0644)
+	git("-C", "lib", "add", ".")
+	git("-C", "lib", "commit", "-q", "-m", "lib")
+	libCommit := git("-C", "lib", "rev-parse", "HEAD")
+
+	git("init", "-q", "-b", "main", "app")
+	git("-C", "app", "submodule", "-q", "add", "file://"+filepath.Join(dir, "lib"), "vendor/lib")
+	git("-C", "app", "commit", "-q", "-m", "app")
+
+	g := gitOps{log.New(os.Stderr, "", 0)}
+	src := filepath.Join(dir, "src")
+
+	if err := g.FetchBranch("file://"+filepath.Join(dir, "app"), "main", src); err != nil {
+		panic(err)
+	}
+
+	commits, err := g.UpdateSubmodules(src, SubmodulesEnabled)
+	if err != nil {
+		panic(err)
+	}
+
+	content, _ := os.ReadFile(filepath.Join(src, "vendor", "lib", "lib.go"))
+	fmt.Println(len(commits), commits["vendor/lib"] == libCommit, strings.TrimSpace(string(content)))
+
+	// Output:
+	// 1 true package lib
+}
+
+func Example_submoduleURL() {
+	for _, parent := range []string{
+		"https://git.example.org/org/app.git",
+		"git@git.example.org:org/app",
+		"/srv/git/app",
+	} {
+		fmt.Println(submoduleURL(parent, "../lib"), submoduleURL(parent, "./sub"))
+	}
+	fmt.Println(submoduleURL("https://git.example.org/org/app", "https://git.other.org/lib"))
+
+	// Output:
+	// https://git.example.org/org/lib https://git.example.org/org/app.git/sub
+	// git@git.example.org:org/lib git@git.example.org:org/app/sub
+	// /srv/git/lib /srv/git/app/sub
+	// https://git.other.org/lib
+}
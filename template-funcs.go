@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// templateFuncs are the functions available to app templates, named and ordered like
+// their sprig counterparts so they can be piped (ie: {{ .name | lower | replace "_" "-" }}).
+// Templates fail on missing keys, so optional values are read with index
+// (ie: {{ index . "image" | default .name }}).
+var templateFuncs = template.FuncMap{
+	"default":  tmplDefault,
+	"empty":    isEmpty,
+	"coalesce": coalesce,
+	"required": required,
+	"ternary": func(vt, vf any, cond bool) any {
+		if cond {
+			return vt
+		}
+		return vf
+	},
+
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+	"trim":       strings.TrimSpace,
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+	"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+	"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+	"splitList":  func(sep, s string) []string { return strings.Split(s, sep) },
+	"join":       tmplJoin,
+	"quote":      func(v any) string { return strconv.Quote(toString(v)) },
+	"squote":     func(v any) string { return "'" + strings.ReplaceAll(toString(v), "'", "''") + "'" },
+	"toString":   toString,
+	"indent":     indent,
+	"nindent":    func(n int, s string) string { return "\n" + indent(n, s) },
+
+	"list": func(v ...any) []any { return v },
+	"dict": dict,
+
+	"toYaml": toYaml,
+	"toJson": toJSON,
+}
+
+func tmplDefault(def any, v ...any) any {
+	if len(v) == 0 || isEmpty(v[0]) {
+		return def
+	}
+	return v[0]
+}
+
+func isEmpty(v any) bool {
+	if v == nil {
+		return true
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return rv.Len() == 0
+	case reflect.Pointer, reflect.Interface:
+		return rv.IsNil()
+	default:
+		return rv.IsZero()
+	}
+}
+
+func coalesce(v ...any) any {
+	for _, value := range v {
+		if !isEmpty(value) {
+			return value
+		}
+	}
+	return nil
+}
+
+func required(msg string, v any) (any, error) {
+	if isEmpty(v) {
+		return nil, errors.New(msg)
+	}
+	return v, nil
+}
+
+func toString(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func tmplJoin(sep string, v any) string {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return toString(v)
+	}
+
+	parts := make([]string, 0, rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		parts = append(parts, toString(rv.Index(i).Interface()))
+	}
+	return strings.Join(parts, sep)
+}
+
+func indent(n int, s string) string {
+	pad := strings.Repeat(" ", n)
+	return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+}
+
+func dict(kv ...any) (map[string]any, error) {
+	if len(kv)%2 != 0 {
+		return nil, errors.New("dict needs key and value pairs")
+	}
+
+	d := make(map[string]any, len(kv)/2)
+	for i := 0; i < len(kv); i += 2 {
+		d[toString(kv[i])] = kv[i+1]
+	}
+	return d, nil
+}
+
+func toYaml(v any) (string, error) {
+	ba, err := yaml.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(ba), "\n"), nil
+}
+
+func toJSON(v any) (string, error) {
+	ba, err := json.Marshal(v)
+	return string(ba), err
+}
+
+// mergeData returns the deep merge of the data maps, later values overriding earlier ones.
+func mergeData(data ...map[string]any) (merged map[string]any) {
+	merged = map[string]any{}
+
+	for _, d := range data {
+		for k, v := range d {
+			v = normalizeData(v)
+
+			vMap, vIsMap := v.(map[string]any)
+			prevMap, prevIsMap := merged[k].(map[string]any)
+
+			if vIsMap && prevIsMap {
+				merged[k] = mergeData(prevMap, vMap)
+			} else {
+				merged[k] = v
+			}
+		}
+	}
+	return
+}
+
+// normalizeData converts the maps decoded by yaml.v2 (with any keys) to maps with string keys.
+func normalizeData(v any) any {
+	switch v := v.(type) {
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for k, value := range v {
+			m[toString(k)] = normalizeData(value)
+		}
+		return m
+	case map[string]any:
+		m := make(map[string]any, len(v))
+		for k, value := range v {
+			m[k] = normalizeData(value)
+		}
+		return m
+	case []any:
+		l := make([]any, len(v))
+		for i, value := range v {
+			l[i] = normalizeData(value)
+		}
+		return l
+	default:
+		return v
+	}
+}
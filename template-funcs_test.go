@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/template"
+)
+
+func Example_templateFuncs() {
+	data := mergeData(
+		map[string]any{"registry": "registry.example.org", "env": map[any]any{"LOG_LEVEL": "info", "TZ": "UTC"}},
+		map[string]any{"name": "Web-Front", "env": map[any]any{"LOG_LEVEL": "debug"}},
+	)
+
+	tmpl := template.Must(template.New("app").Option("missingkey=error").Funcs(templateFuncs).Parse(`name: {{ .name | lower | replace "-" "_" }}
+docker: {{ .registry }}/{{ index . "image" | default .name | lower }}
+env:{{ .env | toYaml | nindent 2 }}
+`))
+
+	if err := tmpl.Execute(os.Stdout, data); err != nil {
+		fmt.Println(err)
+	}
+
+	err := template.Must(template.New("app").Option("missingkey=error").Funcs(templateFuncs).Parse(`{{ .deploy }}`)).Execute(os.Stdout, data)
+	fmt.Println(err != nil)
+
+	// Output:
+	// name: web_front
+	// docker: registry.example.org/web-front
+	// env:
+	//   LOG_LEVEL: debug
+	//   TZ: UTC
+	// true
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// workDirLock serializes deployments between processes sharing the work dir, like the
+// server and the rollback subcommand. globalLock does it within a process; the file lock
+// is reentrant so a deployment can run under a caller already holding it.
+var workDirLock struct {
+	sync.Mutex
+	file  *os.File
+	count int
+}
+
+// lockWorkDir takes the work dir's file lock, waiting for other processes to release it.
+func lockWorkDir() (unlock func(), err error) {
+	workDirLock.Lock()
+	defer workDirLock.Unlock()
+
+	if workDirLock.count == 0 {
+		var f *os.File
+		f, err = os.OpenFile(filepath.Join(*workDir, ".lock"), os.O_CREATE|os.O_RDWR, 0600)
+		if err != nil {
+			err = fmt.Errorf("failed to open work dir lock: %w", err)
+			return
+		}
+
+		if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+			f.Close()
+			err = fmt.Errorf("failed to lock work dir: %w", err)
+			return
+		}
+		workDirLock.file = f
+	}
+	workDirLock.count++
+
+	unlock = func() {
+		workDirLock.Lock()
+		defer workDirLock.Unlock()
+
+		workDirLock.count--
+		if workDirLock.count == 0 {
+			workDirLock.file.Close() // releases the lock
+			workDirLock.file = nil
+		}
+	}
+	return
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+func Example_lockWorkDir() {
+	dir, cleanup := testDir("lock-test")
+	defer cleanup()
+
+	defer func(prevWorkDir string) { *workDir = prevWorkDir }(*workDir)
+	*workDir = dir
+
+	// another process' view of the lock
+	other := func() bool {
+		f, err := os.Open(filepath.Join(dir, ".lock"))
+		if err != nil {
+			panic(err)
+		}
+		defer f.Close()
+		return syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB) == nil
+	}
+
+	unlock, err := lockWorkDir()
+	if err != nil {
+		panic(err)
+	}
+	unlockNested, err := lockWorkDir()
+	if err != nil {
+		panic(err)
+	}
+	unlockNested()
+	fmt.Println("locked, other process can lock:", other())
+
+	unlock()
+	fmt.Println("unlocked, other process can lock:", other())
+
+	// Output:
+	// locked, other process can lock: false
+	// unlocked, other process can lock: true
+}